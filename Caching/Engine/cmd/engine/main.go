@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"Engine"
+)
+
+func main() {
+	env := func(name, def string) string {
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
+	}
+	listen := flag.String("listen", env("ENGINE_LISTEN", ":6379"), "address to serve RESP on (ENGINE_LISTEN)")
+	password := flag.String("password", env("ENGINE_PASSWORD", ""), "password clients must send with AUTH (ENGINE_PASSWORD)")
+	verbose := flag.Bool("verbose", false, "log connection errors")
+	flag.Parse()
+
+	opts := engine.Options{Password: *password}
+	if *verbose {
+		opts.Logger = log.Default()
+	}
+	srv := engine.New(opts)
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v\n", *listen, err)
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		<-signals
+		log.Println("Shutting down")
+		srv.Close()
+	}()
+
+	log.Printf("Cache engine is running on %s...\n", l.Addr())
+	if err := srv.Serve(l); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hashFor returns the hash at key, creating it if create is set. It writes
+// a WRONGTYPE error and returns ok false for a key of another type.
+func (c *conn) hashFor(key string, create bool) (h map[string]string, ok bool) {
+	it, ok := c.lookupType(key, typeHash)
+	if !ok {
+		return nil, false
+	}
+	if it == nil {
+		if !create {
+			return nil, true
+		}
+		it = &item{typ: typeHash, hash: make(map[string]string)}
+		c.keys()[key] = it
+	}
+	return it.hash, true
+}
+
+// dropIfEmpty deletes a hash whose last field was removed, as Redis does.
+func (c *conn) dropIfEmpty(key string, h map[string]string) {
+	if len(h) == 0 {
+		delete(c.keys(), key)
+	}
+}
+
+// cmdHSet implements HSET, which returns the number of new fields, and the
+// deprecated HMSET, which returns OK.
+func cmdHSet(c *conn, args []string) {
+	if len(args)%2 != 0 {
+		c.w.err(errArity(args[0]))
+		return
+	}
+	h, ok := c.hashFor(args[1], true)
+	if !ok {
+		return
+	}
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		if _, exists := h[args[i]]; !exists {
+			added++
+		}
+		h[args[i]] = args[i+1]
+	}
+	if strings.EqualFold(args[0], "HMSET") {
+		c.w.ok()
+		return
+	}
+	c.w.int(added)
+}
+
+func cmdHSetNX(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], true)
+	if !ok {
+		return
+	}
+	if _, exists := h[args[2]]; exists {
+		c.w.int(0)
+		return
+	}
+	h[args[2]] = args[3]
+	c.w.int(1)
+}
+
+func cmdHGet(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	if v, exists := h[args[2]]; exists {
+		c.w.bulk(v)
+		return
+	}
+	c.w.null()
+}
+
+func cmdHMGet(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	c.w.array(len(args) - 2)
+	for _, field := range args[2:] {
+		if v, exists := h[field]; exists {
+			c.w.bulk(v)
+		} else {
+			c.w.null()
+		}
+	}
+}
+
+// sortedFields returns the fields of h in a stable order, so HGETALL, HKEYS
+// and HVALS agree with each other.
+func sortedFields(h map[string]string) []string {
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func cmdHGetAll(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	fields := sortedFields(h)
+	c.w.array(2 * len(fields))
+	for _, field := range fields {
+		c.w.bulk(field)
+		c.w.bulk(h[field])
+	}
+}
+
+func cmdHDel(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	var deleted int64
+	for _, field := range args[2:] {
+		if _, exists := h[field]; exists {
+			delete(h, field)
+			deleted++
+		}
+	}
+	if h != nil {
+		c.dropIfEmpty(args[1], h)
+	}
+	c.w.int(deleted)
+}
+
+func cmdHExists(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	if _, exists := h[args[2]]; exists {
+		c.w.int(1)
+		return
+	}
+	c.w.int(0)
+}
+
+func cmdHLen(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	c.w.int(int64(len(h)))
+}
+
+func cmdHKeys(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	c.w.bulks(sortedFields(h)...)
+}
+
+func cmdHVals(c *conn, args []string) {
+	h, ok := c.hashFor(args[1], false)
+	if !ok {
+		return
+	}
+	fields := sortedFields(h)
+	c.w.array(len(fields))
+	for _, field := range fields {
+		c.w.bulk(h[field])
+	}
+}
+
+func cmdHIncrBy(c *conn, args []string) {
+	delta, ok := parseInt(args[3])
+	if !ok {
+		c.w.err(errNotInteger)
+		return
+	}
+	h, ok := c.hashFor(args[1], true)
+	if !ok {
+		return
+	}
+	var n int64
+	if v, exists := h[args[2]]; exists {
+		if n, ok = parseInt(v); !ok {
+			c.w.err("ERR hash value is not an integer")
+			return
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		c.w.err("ERR increment or decrement would overflow")
+		return
+	}
+	n += delta
+	h[args[2]] = strconv.FormatInt(n, 10)
+	c.w.int(n)
+}
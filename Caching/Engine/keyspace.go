@@ -0,0 +1,523 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value types, as reported by TYPE.
+const (
+	typeString = "string"
+	typeHash   = "hash"
+	typeStream = "stream"
+)
+
+// item is the value stored under a key. Exactly one of str, hash and stream
+// is used, depending on typ.
+type item struct {
+	typ       string
+	str       string
+	hash      map[string]string
+	stream    *stream
+	expiresAt time.Time // zero if the key never expires
+}
+
+func (it *item) expired(now time.Time) bool {
+	return !it.expiresAt.IsZero() && !now.Before(it.expiresAt)
+}
+
+// keys is the selected database. The caller must hold srv.mu.
+func (c *conn) keys() map[string]*item {
+	return c.srv.dbs[c.db]
+}
+
+// lookup returns the live item at key, deleting it if it has expired.
+func (c *conn) lookup(key string) *item {
+	it, ok := c.keys()[key]
+	if !ok {
+		return nil
+	}
+	if it.expired(c.srv.now()) {
+		delete(c.keys(), key)
+		return nil
+	}
+	return it
+}
+
+// lookupType returns the item at key if it holds typ, or writes a WRONGTYPE
+// error and returns ok false.
+func (c *conn) lookupType(key, typ string) (it *item, ok bool) {
+	it = c.lookup(key)
+	if it != nil && it.typ != typ {
+		c.w.err(errWrongType)
+		return nil, false
+	}
+	return it, true
+}
+
+func parseInt(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// ttlArg converts an expiry argument to a deadline. unit is the duration
+// of one unit of n; absolute deadlines are Unix timestamps.
+func (c *conn) ttlArg(value string, unit time.Duration, absolute bool) (time.Time, bool) {
+	n, ok := parseInt(value)
+	if !ok {
+		c.w.err(errNotInteger)
+		return time.Time{}, false
+	}
+	if absolute {
+		return time.Unix(0, 0).Add(time.Duration(n) * unit), true
+	}
+	if n <= 0 || n > math.MaxInt64/int64(unit) {
+		c.w.err(errInvalidTime)
+		return time.Time{}, false
+	}
+	return c.srv.now().Add(time.Duration(n) * unit), true
+}
+
+func cmdDel(c *conn, args []string) {
+	var n int64
+	for _, key := range args[1:] {
+		if c.lookup(key) != nil {
+			delete(c.keys(), key)
+			n++
+		}
+	}
+	c.w.int(n)
+}
+
+func cmdExists(c *conn, args []string) {
+	var n int64
+	for _, key := range args[1:] {
+		if c.lookup(key) != nil {
+			n++
+		}
+	}
+	c.w.int(n)
+}
+
+func cmdType(c *conn, args []string) {
+	if it := c.lookup(args[1]); it != nil {
+		c.w.simple(it.typ)
+		return
+	}
+	c.w.simple("none")
+}
+
+// cmdExpire implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT with the NX,
+// XX, GT and LT conditions.
+func cmdExpire(c *conn, args []string) {
+	name := strings.ToUpper(args[0])
+	unit := time.Second
+	if strings.HasPrefix(name, "P") {
+		unit = time.Millisecond
+	}
+	at, ok := c.ttlArgAllowPast(args[2], unit, strings.HasSuffix(name, "AT"))
+	if !ok {
+		return
+	}
+	var cond string
+	if len(args) > 4 {
+		c.w.err(errSyntax)
+		return
+	}
+	if len(args) == 4 {
+		cond = strings.ToUpper(args[3])
+		if cond != "NX" && cond != "XX" && cond != "GT" && cond != "LT" {
+			c.w.err("ERR Unsupported option " + args[3])
+			return
+		}
+	}
+
+	it := c.lookup(args[1])
+	if it == nil {
+		c.w.int(0)
+		return
+	}
+	// A key without a TTL counts as an infinite TTL for GT and LT.
+	switch {
+	case cond == "NX" && !it.expiresAt.IsZero(),
+		cond == "XX" && it.expiresAt.IsZero(),
+		cond == "GT" && (it.expiresAt.IsZero() || !at.After(it.expiresAt)),
+		cond == "LT" && !it.expiresAt.IsZero() && !at.Before(it.expiresAt):
+		c.w.int(0)
+		return
+	}
+	if !at.After(c.srv.now()) {
+		delete(c.keys(), args[1])
+	} else {
+		it.expiresAt = at
+	}
+	c.w.int(1)
+}
+
+// ttlArgAllowPast is ttlArg for EXPIRE, where a deadline in the past
+// deletes the key instead of being an error.
+func (c *conn) ttlArgAllowPast(value string, unit time.Duration, absolute bool) (time.Time, bool) {
+	n, ok := parseInt(value)
+	if !ok {
+		c.w.err(errNotInteger)
+		return time.Time{}, false
+	}
+	if absolute {
+		return time.Unix(0, 0).Add(time.Duration(n) * unit), true
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		c.w.err(errInvalidTime)
+		return time.Time{}, false
+	}
+	return c.srv.now().Add(time.Duration(n) * unit), true
+}
+
+// cmdTTL implements TTL and PTTL: -2 for a missing key, -1 for a key
+// without an expiry.
+func cmdTTL(c *conn, args []string) {
+	it := c.lookup(args[1])
+	switch {
+	case it == nil:
+		c.w.int(-2)
+	case it.expiresAt.IsZero():
+		c.w.int(-1)
+	default:
+		left := it.expiresAt.Sub(c.srv.now())
+		if strings.ToUpper(args[0]) == "PTTL" {
+			c.w.int(int64((left + time.Millisecond - 1) / time.Millisecond))
+		} else {
+			c.w.int(int64((left + time.Second/2) / time.Second))
+		}
+	}
+}
+
+func cmdPersist(c *conn, args []string) {
+	it := c.lookup(args[1])
+	if it == nil || it.expiresAt.IsZero() {
+		c.w.int(0)
+		return
+	}
+	it.expiresAt = time.Time{}
+	c.w.int(1)
+}
+
+// liveKeys returns the unexpired keys of the selected database matching
+// pattern, sorted so SCAN cursors are stable.
+func (c *conn) liveKeys(pattern string) []string {
+	now := c.srv.now()
+	var keys []string
+	for key, it := range c.keys() {
+		if it.expired(now) {
+			delete(c.keys(), key)
+			continue
+		}
+		if pattern == "*" || match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func cmdKeys(c *conn, args []string) {
+	c.w.bulks(c.liveKeys(args[1])...)
+}
+
+// cmdScan pages through the sorted key space. The cursor is the index of the
+// next key, so keys added or removed between calls may shift the pages, which
+// SCAN's guarantees allow for keys that change during the iteration.
+func cmdScan(c *conn, args []string) {
+	cursor, ok := parseInt(args[1])
+	if !ok || cursor < 0 {
+		c.w.err("ERR invalid cursor")
+		return
+	}
+	pattern, count, typ := "*", int64(10), ""
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.err(errSyntax)
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, ok = parseInt(args[i+1]); !ok || count < 1 {
+				c.w.err(errSyntax)
+				return
+			}
+		case "TYPE":
+			typ = strings.ToLower(args[i+1])
+		default:
+			c.w.err(errSyntax)
+			return
+		}
+	}
+
+	keys := c.liveKeys("*")
+	end := min(cursor+count, int64(len(keys)))
+	var page []string
+	for _, key := range keys[min(cursor, end):end] {
+		if (pattern == "*" || match(pattern, key)) && (typ == "" || c.keys()[key].typ == typ) {
+			page = append(page, key)
+		}
+	}
+	next := end
+	if next >= int64(len(keys)) {
+		next = 0
+	}
+	c.w.array(2)
+	c.w.bulk(strconv.FormatInt(next, 10))
+	c.w.bulks(page...)
+}
+
+func cmdDBSize(c *conn, args []string) {
+	c.w.int(int64(len(c.liveKeys("*"))))
+}
+
+func cmdFlushDB(c *conn, args []string) {
+	c.srv.dbs[c.db] = make(map[string]*item)
+	c.w.ok()
+}
+
+func cmdFlushAll(c *conn, args []string) {
+	for i := range c.srv.dbs {
+		c.srv.dbs[i] = make(map[string]*item)
+	}
+	c.w.ok()
+}
+
+// match reports whether s matches the glob pattern used by KEYS, SCAN and
+// PSUBSCRIBE: * and ? wildcards, [abc], [^abc] and [a-z] classes and \ to
+// escape.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// An unterminated class matches a literal '['.
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			if inClass(class, s[0]) == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func inClass(class string, b byte) bool {
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+		} else if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= b && b <= hi {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Connection commands.
+
+func cmdPing(c *conn, args []string) {
+	if len(args) > 2 {
+		c.w.err(errArity(args[0]))
+		return
+	}
+	if c.subscribed() {
+		// Subscribed clients get PING replies in the message format.
+		msg := ""
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		c.w.bulks("pong", msg)
+		return
+	}
+	if len(args) == 2 {
+		c.w.bulk(args[1])
+		return
+	}
+	c.w.simple("PONG")
+}
+
+func cmdEcho(c *conn, args []string) {
+	c.w.bulk(args[1])
+}
+
+func cmdAuth(c *conn, args []string) {
+	if len(args) > 3 {
+		c.w.err(errSyntax)
+		return
+	}
+	if c.srv.opts.Password == "" {
+		c.w.err("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	// AUTH password or AUTH username password; only the default user exists.
+	if args[len(args)-1] != c.srv.opts.Password || (len(args) == 3 && args[1] != "default") {
+		c.w.err("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.authed = true
+	c.w.ok()
+}
+
+// cmdHello refuses every protocol version, so clients fall back to RESP2 and
+// authenticate with AUTH.
+func cmdHello(c *conn, args []string) {
+	c.w.err("NOPROTO this server only speaks RESP2")
+}
+
+func cmdSelect(c *conn, args []string) {
+	n, ok := parseInt(args[1])
+	if !ok {
+		c.w.err(errNotInteger)
+		return
+	}
+	if n < 0 || n >= Databases {
+		c.w.err("ERR DB index is out of range")
+		return
+	}
+	c.db = int(n)
+	c.w.ok()
+}
+
+// cmdClient accepts the CLIENT subcommands clients send while connecting.
+func cmdClient(c *conn, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "SETNAME", "SETINFO", "NO-EVICT", "NO-TOUCH":
+		c.w.ok()
+	case "GETNAME":
+		c.w.null()
+	case "ID":
+		c.w.int(1)
+	default:
+		c.w.err(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+}
+
+func cmdQuit(c *conn, args []string) {
+	c.quit = true
+	c.w.ok()
+}
+
+func cmdInfo(c *conn, args []string) {
+	now := c.srv.now()
+	var b strings.Builder
+	b.WriteString("# Server\r\nredis_version:7.2.0\r\nredis_mode:standalone\r\n")
+	fmt.Fprintf(&b, "\r\n# Clients\r\nconnected_clients:%d\r\n", len(c.srv.conns))
+	b.WriteString("\r\n# Replication\r\nrole:master\r\nconnected_slaves:0\r\n")
+	b.WriteString("\r\n# Keyspace\r\n")
+	for i, db := range c.srv.dbs {
+		keys, expires := 0, 0
+		for _, it := range db {
+			if it.expired(now) {
+				continue
+			}
+			keys++
+			if !it.expiresAt.IsZero() {
+				expires++
+			}
+		}
+		if keys > 0 {
+			fmt.Fprintf(&b, "db%d:keys=%d,expires=%d,avg_ttl=0\r\n", i, keys, expires)
+		}
+	}
+	c.w.bulk(b.String())
+}
+
+// Transactions. Queued commands run back to back without releasing the
+// keyspace lock, so no other client sees a partial transaction. WATCH is not
+// supported.
+
+func cmdMulti(c *conn, args []string) {
+	if c.multi {
+		c.w.err("ERR MULTI calls can not be nested")
+		return
+	}
+	c.multi = true
+	c.w.ok()
+}
+
+func cmdExec(c *conn, args []string) {
+	if !c.multi {
+		c.w.err("ERR EXEC without MULTI")
+		return
+	}
+	queued, aborted := c.queued, c.aborted
+	c.multi, c.queued, c.aborted = false, nil, false
+	if aborted {
+		c.w.err("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	c.w.array(len(queued))
+	c.inExec = true
+	for _, args := range queued {
+		c.dispatch(args)
+	}
+	c.inExec = false
+}
+
+func cmdDiscard(c *conn, args []string) {
+	if !c.multi {
+		c.w.err("ERR DISCARD without MULTI")
+		return
+	}
+	c.multi, c.queued, c.aborted = false, nil, false
+	c.w.ok()
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// cmdPublish delivers a message to the subscribers of a channel and of the
+// patterns matching it, and returns how many received it. Messages are
+// queued in the outbox and written once the keyspace lock is released.
+func cmdPublish(c *conn, args []string) {
+	channel, msg := args[1], args[2]
+	receivers := 0
+	for sub := range c.srv.channels[channel] {
+		c.outbox = append(c.outbox, delivery{sub, []string{"message", channel, msg}})
+		receivers++
+	}
+	for pattern, subs := range c.srv.patterns {
+		if !match(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			c.outbox = append(c.outbox, delivery{sub, []string{"pmessage", pattern, channel, msg}})
+			receivers++
+		}
+	}
+	c.w.int(int64(receivers))
+}
+
+// cmdSubscribe implements SUBSCRIBE and PSUBSCRIBE. Every channel is
+// confirmed with a reply carrying the connection's subscription count.
+func cmdSubscribe(c *conn, args []string) {
+	kind, own, all := "subscribe", c.channels, c.srv.channels
+	if strings.ToUpper(args[0]) == "PSUBSCRIBE" {
+		kind, own, all = "psubscribe", c.patterns, c.srv.patterns
+	}
+	for _, name := range args[1:] {
+		if !own[name] {
+			own[name] = true
+			if all[name] == nil {
+				all[name] = make(map[*conn]bool)
+			}
+			all[name][c] = true
+		}
+		c.subscriptionReply(kind, name)
+	}
+}
+
+// cmdUnsubscribe implements UNSUBSCRIBE and PUNSUBSCRIBE. Without arguments
+// it drops every subscription of that kind.
+func cmdUnsubscribe(c *conn, args []string) {
+	kind, own, all := "unsubscribe", c.channels, c.srv.channels
+	if strings.ToUpper(args[0]) == "PUNSUBSCRIBE" {
+		kind, own, all = "punsubscribe", c.patterns, c.srv.patterns
+	}
+	names := args[1:]
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			c.w.array(3)
+			c.w.bulk(kind)
+			c.w.null()
+			c.w.int(int64(len(c.channels) + len(c.patterns)))
+			return
+		}
+	}
+	for _, name := range names {
+		unsubscribe(c, name, own, all)
+		c.subscriptionReply(kind, name)
+	}
+}
+
+func (c *conn) subscriptionReply(kind, name string) {
+	c.w.array(3)
+	c.w.bulk(kind)
+	c.w.bulk(name)
+	c.w.int(int64(len(c.channels) + len(c.patterns)))
+}
+
+func unsubscribe(c *conn, name string, own map[string]bool, all map[string]map[*conn]bool) {
+	delete(own, name)
+	delete(all[name], c)
+	if len(all[name]) == 0 {
+		delete(all, name)
+	}
+}
+
+// unsubscribeAll is called when the connection closes. The caller must hold
+// srv.mu.
+func (c *conn) unsubscribeAll() {
+	for name := range c.channels {
+		unsubscribe(c, name, c.channels, c.srv.channels)
+	}
+	for name := range c.patterns {
+		unsubscribe(c, name, c.patterns, c.srv.patterns)
+	}
+}
+
+// cmdPubsub implements PUBSUB CHANNELS [pattern], NUMSUB [channel ...] and
+// NUMPAT.
+func cmdPubsub(c *conn, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "CHANNELS":
+		pattern := "*"
+		if len(args) > 2 {
+			pattern = args[2]
+		}
+		var names []string
+		for name := range c.srv.channels {
+			if match(pattern, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		c.w.bulks(names...)
+	case "NUMSUB":
+		c.w.array(2 * (len(args) - 2))
+		for _, name := range args[2:] {
+			c.w.bulk(name)
+			c.w.int(int64(len(c.srv.channels[name])))
+		}
+	case "NUMPAT":
+		c.w.int(int64(len(c.srv.patterns)))
+	default:
+		c.w.err("ERR unknown subcommand '" + args[1] + "'")
+	}
+}
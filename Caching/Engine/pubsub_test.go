@@ -0,0 +1,86 @@
+package engine_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPublishV8(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	sub := newV8(t, srv).Subscribe(ctx, "news")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pub := newV8(t, srv)
+
+	if n := pub.Publish(ctx, "news", "hello").Val(); n != 1 {
+		t.Errorf("PUBLISH news = %d receivers, want 1", n)
+	}
+	if n := pub.Publish(ctx, "sports", "ignored").Val(); n != 0 {
+		t.Errorf("PUBLISH sports = %d receivers, want 0", n)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	msg, err := sub.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Channel != "news" || msg.Payload != "hello" {
+		t.Errorf("received %s: %q, want news: hello", msg.Channel, msg.Payload)
+	}
+}
+
+func TestPublishV9(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	sub := newV9(t, srv).PSubscribe(ctx, "user:*")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Subscribe(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pub := newV9(t, srv)
+
+	// The subscriber gets the message once for the channel and once for
+	// the pattern.
+	if n := pub.Publish(ctx, "user:1", "renamed").Val(); n != 2 {
+		t.Errorf("PUBLISH user:1 = %d receivers, want 2", n)
+	}
+	ch := sub.Channel()
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-ch:
+			if msg.Channel != "user:1" || msg.Payload != "renamed" {
+				t.Errorf("received %s: %q, want user:1: renamed", msg.Channel, msg.Payload)
+			}
+			if msg.Pattern != "" && msg.Pattern != "user:*" {
+				t.Errorf("message pattern = %q, want user:*", msg.Pattern)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of 2 messages", i)
+		}
+	}
+
+	if err := sub.Unsubscribe(ctx, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.PUnsubscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the unsubscriptions to be processed before publishing.
+	deadline := time.Now().Add(5 * time.Second)
+	for pub.PubSubNumPat(ctx).Val() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := pub.Publish(ctx, "user:1", "unheard").Val(); n != 0 {
+		t.Errorf("PUBLISH after unsubscribing = %d receivers, want 0", n)
+	}
+}
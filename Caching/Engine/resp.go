@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits on what a client may send, so a broken or hostile client cannot
+// make the engine allocate without bound.
+const (
+	maxArgs     = 1 << 20
+	maxBulkSize = 512 << 20
+	maxInline   = 64 << 10
+)
+
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads one command: a RESP array of bulk strings as sent by
+// client libraries, or an inline command line as typed into telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		if len(line) > maxInline {
+			return nil, fmt.Errorf("%w: inline command too long", errProtocol)
+		}
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", errProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine returns the next line without its CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writer encodes RESP2 replies. Replies are buffered and flushed once all
+// pipelined commands read so far have been answered.
+type writer struct {
+	w *bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) ok() {
+	w.simple("OK")
+}
+
+func (w writer) err(msg string) {
+	w.w.WriteString("-" + msg + "\r\n")
+}
+
+func (w writer) int(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// null is the nil bulk string, which clients report as a missing key.
+func (w writer) null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// nullArray is the nil array, e.g. a blocking read that timed out.
+func (w writer) nullArray() {
+	w.w.WriteString("*-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulks(items ...string) {
+	w.array(len(items))
+	for _, item := range items {
+		w.bulk(item)
+	}
+}
+
+// Error replies shared by many commands.
+const (
+	errWrongType   = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errSyntax      = "ERR syntax error"
+	errNotInteger  = "ERR value is not an integer or out of range"
+	errInvalidTime = "ERR invalid expire time"
+)
+
+func errArity(cmd string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
@@ -0,0 +1,473 @@
+// Package engine is a small in-memory cache that speaks the Redis protocol
+// (RESP2). It supports strings with TTLs, hashes, streams with consumer
+// groups, pub/sub and MULTI/EXEC, which covers what the programs under
+// Caching/ and AsyncQueueing/ ask of Redis, so they can run without one.
+//
+// It runs as a standalone binary (cmd/engine) or inside a Go program:
+//
+//	srv, err := engine.Start("127.0.0.1:0")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Close()
+//	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+//
+// Like Redis, commands run one at a time: every command holds a single lock
+// on the keyspace. There is no persistence, replication or cluster support.
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Databases is the number of databases SELECT can switch between.
+const Databases = 16
+
+// Options configure a Server.
+type Options struct {
+	// Password, if set, must be sent with AUTH before any other command.
+	Password string
+	// Logger receives connection errors. Nil discards them.
+	Logger *log.Logger
+}
+
+// Server is one engine instance. The zero value is not usable; create one
+// with New or Start.
+type Server struct {
+	opts Options
+
+	mu  sync.Mutex // guards everything below
+	dbs [Databases]map[string]*item
+	// offset is added to the wall clock, so fixtures can expire keys
+	// without sleeping.
+	offset time.Duration
+	// changed is closed and replaced whenever a stream gets new entries,
+	// waking blocked XREAD and XREADGROUP calls.
+	changed  chan struct{}
+	channels map[string]map[*conn]bool
+	patterns map[string]map[*conn]bool
+	conns    map[*conn]bool
+	listener net.Listener
+	closed   bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns a server that is not listening yet; see Serve.
+func New(opts Options) *Server {
+	s := &Server{
+		opts:     opts,
+		changed:  make(chan struct{}),
+		channels: make(map[string]map[*conn]bool),
+		patterns: make(map[string]map[*conn]bool),
+		conns:    make(map[*conn]bool),
+		done:     make(chan struct{}),
+	}
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+	s.wg.Add(1)
+	go s.expireLoop()
+	return s
+}
+
+// Start listens on addr, e.g. "127.0.0.1:0" for a free port, and serves in
+// the background until Close.
+func Start(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := New(Options{})
+	s.listener = l // so Addr works before Serve runs
+	go s.Serve(l)
+	return s, nil
+}
+
+// Serve accepts connections on l until Close. It returns nil after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		c := newConn(s, nc)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return nil
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Addr is the address the server listens on, for clients to dial.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops listening, disconnects every client and waits for their
+// goroutines to finish. The data is lost.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Advance moves the server clock forward by d, expiring keys as if d had
+// passed.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// FlushAll removes every key of every database.
+func (s *Server) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+}
+
+// now is the server clock. The caller must hold s.mu.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// notify wakes blocked stream readers. The caller must hold s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.opts.Logger != nil {
+		s.opts.Logger.Printf(format, args...)
+	}
+}
+
+// expireLoop removes expired keys that are never read again, sampling a few
+// keys of every database ten times a second like Redis does.
+func (s *Server) expireLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		now := s.now()
+		for _, db := range s.dbs {
+			sampled := 0
+			for key, it := range db {
+				if it.expired(now) {
+					delete(db, key)
+				}
+				if sampled++; sampled == 20 {
+					break
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// conn is one client connection.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	r   *bufio.Reader
+
+	// mu guards w. Pub/sub messages are written by the publishing
+	// connection's goroutine.
+	mu sync.Mutex
+	w  writer
+
+	// The fields below are only used by the connection's own goroutine,
+	// with srv.mu held.
+	db       int
+	authed   bool
+	multi    bool
+	queued   [][]string
+	aborted  bool // a command failed to queue, so EXEC must fail
+	inExec   bool // blocking commands do not block inside MULTI
+	channels map[string]bool
+	patterns map[string]bool
+	outbox   []delivery // messages to write after srv.mu is released
+	quit     bool
+}
+
+// delivery is a pub/sub message for another connection.
+type delivery struct {
+	to   *conn
+	args []string
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{
+		srv:      s,
+		nc:       nc,
+		r:        bufio.NewReader(nc),
+		w:        writer{bufio.NewWriter(nc)},
+		authed:   s.opts.Password == "",
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+}
+
+func (c *conn) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+func (c *conn) serve() {
+	defer c.srv.wg.Done()
+	defer c.close()
+	for !c.quit {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.mu.Lock()
+				c.w.err("ERR " + err.Error())
+				c.w.w.Flush()
+				c.mu.Unlock()
+			} else if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				c.srv.logf("engine: reading from %s: %v", c.nc.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		c.mu.Lock()
+		c.srv.mu.Lock()
+		c.dispatch(args)
+		outbox := c.outbox
+		c.outbox = nil
+		c.srv.mu.Unlock()
+		for _, d := range outbox {
+			d.to.push(d.args)
+		}
+		if c.r.Buffered() == 0 || c.quit {
+			err = c.w.w.Flush()
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// push writes a pub/sub message to c and flushes it.
+func (c *conn) push(args []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.bulks(args...)
+	c.nc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := c.w.w.Flush(); err != nil {
+		c.nc.Close()
+	}
+	c.nc.SetWriteDeadline(time.Time{})
+}
+
+func (c *conn) close() {
+	c.nc.Close()
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	c.unsubscribeAll()
+	delete(c.srv.conns, c)
+}
+
+// dispatch runs one command. The caller holds c.mu and srv.mu.
+func (c *conn) dispatch(args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		if c.multi {
+			c.aborted = true
+		}
+		c.w.err("ERR unknown command '" + args[0] + "'")
+		return
+	}
+	if !c.authed && !cmd.noAuth {
+		c.w.err("NOAUTH Authentication required.")
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		if c.multi {
+			c.aborted = true
+		}
+		c.w.err(errArity(name))
+		return
+	}
+	if c.subscribed() && !cmd.pubsub {
+		c.w.err("ERR Can't execute '" + strings.ToLower(name) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		return
+	}
+	if c.multi && !cmd.tx {
+		c.queued = append(c.queued, args)
+		c.w.simple("QUEUED")
+		return
+	}
+	cmd.fn(c, args)
+}
+
+// command describes one command. arity counts the command name; a negative
+// arity is a minimum.
+type command struct {
+	fn     func(c *conn, args []string)
+	arity  int
+	noAuth bool // allowed before AUTH
+	pubsub bool // allowed while subscribed
+	tx     bool // runs immediately inside MULTI instead of being queued
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		// Connection
+		"PING":   {fn: cmdPing, arity: -1, pubsub: true},
+		"ECHO":   {fn: cmdEcho, arity: 2},
+		"AUTH":   {fn: cmdAuth, arity: -2, noAuth: true},
+		"HELLO":  {fn: cmdHello, arity: -1, noAuth: true},
+		"SELECT": {fn: cmdSelect, arity: 2},
+		"CLIENT": {fn: cmdClient, arity: -2},
+		"QUIT":   {fn: cmdQuit, arity: 1, noAuth: true, pubsub: true},
+		"INFO":   {fn: cmdInfo, arity: -1},
+		"COMMAND": {fn: func(c *conn, args []string) {
+			c.w.array(0)
+		}, arity: -1},
+		"READONLY":  {fn: func(c *conn, args []string) { c.w.ok() }, arity: 1},
+		"READWRITE": {fn: func(c *conn, args []string) { c.w.ok() }, arity: 1},
+
+		// Keys
+		"DEL":       {fn: cmdDel, arity: -2},
+		"UNLINK":    {fn: cmdDel, arity: -2},
+		"EXISTS":    {fn: cmdExists, arity: -2},
+		"TYPE":      {fn: cmdType, arity: 2},
+		"EXPIRE":    {fn: cmdExpire, arity: -3},
+		"PEXPIRE":   {fn: cmdExpire, arity: -3},
+		"EXPIREAT":  {fn: cmdExpire, arity: -3},
+		"PEXPIREAT": {fn: cmdExpire, arity: -3},
+		"TTL":       {fn: cmdTTL, arity: 2},
+		"PTTL":      {fn: cmdTTL, arity: 2},
+		"PERSIST":   {fn: cmdPersist, arity: 2},
+		"KEYS":      {fn: cmdKeys, arity: 2},
+		"SCAN":      {fn: cmdScan, arity: -2},
+		"DBSIZE":    {fn: cmdDBSize, arity: 1},
+		"FLUSHDB":   {fn: cmdFlushDB, arity: -1},
+		"FLUSHALL":  {fn: cmdFlushAll, arity: -1},
+
+		// Strings
+		"GET":    {fn: cmdGet, arity: 2},
+		"SET":    {fn: cmdSet, arity: -3},
+		"SETNX":  {fn: cmdSetNX, arity: 3},
+		"SETEX":  {fn: cmdSetEX, arity: 4},
+		"PSETEX": {fn: cmdSetEX, arity: 4},
+		"GETDEL": {fn: cmdGetDel, arity: 2},
+		"MGET":   {fn: cmdMGet, arity: -2},
+		"MSET":   {fn: cmdMSet, arity: -3},
+		"INCR":   {fn: cmdIncr, arity: 2},
+		"DECR":   {fn: cmdIncr, arity: 2},
+		"INCRBY": {fn: cmdIncr, arity: 3},
+		"DECRBY": {fn: cmdIncr, arity: 3},
+		"APPEND": {fn: cmdAppend, arity: 3},
+		"STRLEN": {fn: cmdStrlen, arity: 2},
+
+		// Hashes
+		"HSET":    {fn: cmdHSet, arity: -4},
+		"HMSET":   {fn: cmdHSet, arity: -4},
+		"HSETNX":  {fn: cmdHSetNX, arity: 4},
+		"HGET":    {fn: cmdHGet, arity: 3},
+		"HMGET":   {fn: cmdHMGet, arity: -3},
+		"HGETALL": {fn: cmdHGetAll, arity: 2},
+		"HDEL":    {fn: cmdHDel, arity: -3},
+		"HEXISTS": {fn: cmdHExists, arity: 3},
+		"HLEN":    {fn: cmdHLen, arity: 2},
+		"HKEYS":   {fn: cmdHKeys, arity: 2},
+		"HVALS":   {fn: cmdHVals, arity: 2},
+		"HINCRBY": {fn: cmdHIncrBy, arity: 4},
+
+		// Streams
+		"XADD":       {fn: cmdXAdd, arity: -5},
+		"XLEN":       {fn: cmdXLen, arity: 2},
+		"XRANGE":     {fn: cmdXRange, arity: -4},
+		"XREVRANGE":  {fn: cmdXRange, arity: -4},
+		"XDEL":       {fn: cmdXDel, arity: -3},
+		"XTRIM":      {fn: cmdXTrim, arity: -4},
+		"XREAD":      {fn: cmdXRead, arity: -4},
+		"XGROUP":     {fn: cmdXGroup, arity: -2},
+		"XREADGROUP": {fn: cmdXRead, arity: -7},
+		"XACK":       {fn: cmdXAck, arity: -4},
+		"XPENDING":   {fn: cmdXPending, arity: -3},
+		"XCLAIM":     {fn: cmdXClaim, arity: -6},
+
+		// Pub/sub
+		"PUBLISH":      {fn: cmdPublish, arity: 3},
+		"SUBSCRIBE":    {fn: cmdSubscribe, arity: -2, pubsub: true},
+		"PSUBSCRIBE":   {fn: cmdSubscribe, arity: -2, pubsub: true},
+		"UNSUBSCRIBE":  {fn: cmdUnsubscribe, arity: -1, pubsub: true},
+		"PUNSUBSCRIBE": {fn: cmdUnsubscribe, arity: -1, pubsub: true},
+		"PUBSUB":       {fn: cmdPubsub, arity: -2},
+
+		// Transactions
+		"MULTI":   {fn: cmdMulti, arity: 1, tx: true},
+		"EXEC":    {fn: cmdExec, arity: 1, tx: true},
+		"DISCARD": {fn: cmdDiscard, arity: 1, tx: true},
+	}
+}
@@ -0,0 +1,288 @@
+package engine_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/redis/go-redis/v9"
+
+	"Engine"
+)
+
+// start runs an engine for the duration of the test.
+func start(t *testing.T) *engine.Server {
+	t.Helper()
+	srv, err := engine.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func newV8(t *testing.T, srv *engine.Server) *redisv8.Client {
+	rdb := redisv8.NewClient(&redisv8.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func newV9(t *testing.T, srv *engine.Server) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestExpiryV8(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	rdb := newV8(t, srv)
+
+	if err := rdb.Set(ctx, "short", "a", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.Set(ctx, "long", "b", time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.Set(ctx, "forever", "c", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Advance(59 * time.Second)
+	if ttl := rdb.PTTL(ctx, "short").Val(); ttl <= 0 || ttl > time.Second {
+		t.Errorf("PTTL short after 59s = %v, want at most 1s", ttl)
+	}
+	srv.Advance(2 * time.Second)
+	if err := rdb.Get(ctx, "short").Err(); !errors.Is(err, redisv8.Nil) {
+		t.Errorf("GET short after its TTL: err = %v, want redis.Nil", err)
+	}
+	if got := rdb.Get(ctx, "long").Val(); got != "b" {
+		t.Errorf("GET long = %q, want b", got)
+	}
+	if ttl := rdb.TTL(ctx, "forever").Val(); ttl != -1 {
+		t.Errorf("TTL forever = %v, want -1", ttl)
+	}
+	if n := rdb.Exists(ctx, "short", "long", "forever").Val(); n != 2 {
+		t.Errorf("EXISTS = %d, want 2", n)
+	}
+
+	if !rdb.Expire(ctx, "forever", time.Second).Val() {
+		t.Fatal("EXPIRE forever returned false")
+	}
+	srv.Advance(time.Second)
+	if err := rdb.Get(ctx, "forever").Err(); !errors.Is(err, redisv8.Nil) {
+		t.Errorf("GET forever after EXPIRE: err = %v, want redis.Nil", err)
+	}
+	if ttl := rdb.TTL(ctx, "forever").Val(); ttl != -2 {
+		t.Errorf("TTL of an expired key = %v, want -2", ttl)
+	}
+}
+
+func TestExpiryV9(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	rdb := newV9(t, srv)
+
+	if err := rdb.SetEx(ctx, "session", "a", 30*time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.HSet(ctx, "user:1", "name", "alice").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.PExpire(ctx, "user:1", 1500*time.Millisecond).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Advance(time.Second)
+	if got := rdb.HGet(ctx, "user:1", "name").Val(); got != "alice" {
+		t.Errorf("HGET before the TTL = %q, want alice", got)
+	}
+	srv.Advance(time.Second)
+	if err := rdb.HGet(ctx, "user:1", "name").Err(); !errors.Is(err, redis.Nil) {
+		t.Errorf("HGET after the TTL: err = %v, want redis.Nil", err)
+	}
+	if got := rdb.Get(ctx, "session").Val(); got != "a" {
+		t.Errorf("GET session = %q, want a", got)
+	}
+
+	// PERSIST keeps the key past its old deadline.
+	if !rdb.Persist(ctx, "session").Val() {
+		t.Fatal("PERSIST session returned false")
+	}
+	srv.Advance(time.Hour)
+	if got := rdb.Get(ctx, "session").Val(); got != "a" {
+		t.Errorf("GET session after PERSIST = %q, want a", got)
+	}
+	if keys := rdb.Keys(ctx, "*").Val(); len(keys) != 1 || keys[0] != "session" {
+		t.Errorf("KEYS * = %v, want [session]", keys)
+	}
+}
+
+func TestTransactionV8(t *testing.T) {
+	ctx := context.Background()
+	rdb := newV8(t, start(t))
+
+	cmds, err := rdb.TxPipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		pipe.Set(ctx, "counter", "1", 0)
+		pipe.Incr(ctx, "counter")
+		pipe.Get(ctx, "counter")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cmds[2].(*redisv8.StringCmd).Val(); got != "2" {
+		t.Errorf("GET inside the transaction = %q, want 2", got)
+	}
+
+	// A command that fails while running does not roll back the others.
+	rdb.Set(ctx, "name", "alice", 0)
+	cmds, err = rdb.TxPipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		pipe.Incr(ctx, "name")
+		pipe.Set(ctx, "after", "yes", 0)
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "ERR value is not an integer") {
+		t.Errorf("EXEC with a failing INCR: err = %v, want the INCR error", err)
+	}
+	if cmds[1].Err() != nil {
+		t.Errorf("SET after the failing INCR: err = %v", cmds[1].Err())
+	}
+	if got := rdb.Get(ctx, "after").Val(); got != "yes" {
+		t.Errorf("GET after = %q, want yes", got)
+	}
+
+	// A command rejected while queueing discards the whole transaction.
+	_, err = rdb.TxPipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		pipe.Set(ctx, "discarded", "1", 0)
+		pipe.Do(ctx, "get")
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Errorf("EXEC after a queueing error: err = %v, want EXECABORT", err)
+	}
+	if n := rdb.Exists(ctx, "discarded").Val(); n != 0 {
+		t.Errorf("EXISTS discarded = %d, want 0", n)
+	}
+}
+
+func TestTransactionV9(t *testing.T) {
+	ctx := context.Background()
+	rdb := newV9(t, start(t))
+
+	rdb.HSet(ctx, "user:1", "name", "alice")
+	cmds, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "user:1")
+		pipe.HIncrBy(ctx, "user:1", "logins", 1)
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Errorf("EXEC with GET on a hash: err = %v, want WRONGTYPE", err)
+	}
+	if got := cmds[1].(*redis.IntCmd).Val(); got != 1 {
+		t.Errorf("HINCRBY after the failing GET = %d, want 1", got)
+	}
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "discarded")
+		pipe.Do(ctx, "nosuchcommand")
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Errorf("EXEC after an unknown command: err = %v, want EXECABORT", err)
+	}
+	if n := rdb.Exists(ctx, "discarded").Val(); n != 0 {
+		t.Errorf("EXISTS discarded = %d, want 0", n)
+	}
+
+	// The connection is usable again after an aborted transaction.
+	if err := rdb.Do(ctx, "exec").Err(); err == nil || err.Error() != "ERR EXEC without MULTI" {
+		t.Errorf("EXEC without MULTI: err = %v", err)
+	}
+	if got := rdb.Ping(ctx).Val(); got != "PONG" {
+		t.Errorf("PING = %q, want PONG", got)
+	}
+}
+
+// TestProtocolErrors sends malformed input on a raw connection. The engine
+// answers with an error and closes the connection, like Redis.
+func TestProtocolErrors(t *testing.T) {
+	srv := start(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"bad multibulk length", "*x\r\n", "-ERR protocol error: invalid multibulk length"},
+		{"huge multibulk length", "*9999999999\r\n", "-ERR protocol error: invalid multibulk length"},
+		{"missing bulk", "*1\r\n:1\r\n", `-ERR protocol error: expected '$', got ":1"`},
+		{"negative bulk length", "*1\r\n$-3\r\n", "-ERR protocol error: invalid bulk length"},
+		{"bad bulk length", "*2\r\n$3\r\nGET\r\n$a\r\n", "-ERR protocol error: invalid bulk length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, err := net.DialTimeout("tcp", srv.Addr(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+			nc.SetDeadline(time.Now().Add(5 * time.Second))
+			r := bufio.NewReader(nc)
+
+			// A valid command first, so the error is not just a refused
+			// connection.
+			if _, err := io.WriteString(nc, "*1\r\n$4\r\nPING\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			if line, _ := r.ReadString('\n'); line != "+PONG\r\n" {
+				t.Fatalf("PING reply = %q", line)
+			}
+
+			if _, err := io.WriteString(nc, tt.input); err != nil {
+				t.Fatal(err)
+			}
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(line, "\r\n"); got != tt.want {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("read after the error: err = %v, want EOF", err)
+			}
+		})
+	}
+
+	// Unknown commands and wrong arities are ordinary errors that leave the
+	// connection open, for RESP arrays and inline commands alike.
+	nc, err := net.DialTimeout("tcp", srv.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(nc, "*1\r\n$5\r\nBOGUS\r\nGET\r\nSET k v\r\nGET k\r\n")
+	r := bufio.NewReader(nc)
+	for _, want := range []string{
+		"-ERR unknown command 'BOGUS'\r\n",
+		"-ERR wrong number of arguments for 'get' command\r\n",
+		"+OK\r\n",
+		"$1\r\n",
+		"v\r\n",
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("reply line = %q, want %q", line, want)
+		}
+	}
+}
@@ -0,0 +1,1058 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID is an entry ID, <milliseconds>-<sequence>.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+// next is the smallest ID greater than id.
+func (id streamID) next() streamID {
+	if id.seq == math.MaxUint64 {
+		return streamID{id.ms + 1, 0}
+	}
+	return streamID{id.ms, id.seq + 1}
+}
+
+// parseStreamID parses "ms-seq" or "ms", which means ms-defaultSeq.
+func parseStreamID(s string, defaultSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms, defaultSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms, seq}, true
+}
+
+const errInvalidStreamID = "ERR Invalid stream ID specified as stream command argument"
+
+type streamEntry struct {
+	id     streamID
+	fields []string // field, value, field, value, ...
+}
+
+type stream struct {
+	entries []streamEntry // ascending by id
+	lastID  streamID      // never decreases, even when entries are deleted
+	groups  map[string]*group
+}
+
+// group is a consumer group. Entries delivered to a consumer stay pending
+// until acknowledged with XACK.
+type group struct {
+	lastID    streamID // last entry delivered with ">"
+	pending   map[streamID]*pendingEntry
+	consumers map[string]*consumer
+}
+
+type pendingEntry struct {
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+type consumer struct {
+	seenAt time.Time
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*group)}
+}
+
+// search returns the index of the first entry with an ID not less than id.
+func (s *stream) search(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(id) })
+}
+
+func (s *stream) entry(id streamID) (streamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		return s.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// after returns up to count entries with IDs greater than id; count 0 means
+// all of them.
+func (s *stream) after(id streamID, count int) []streamEntry {
+	if id == maxStreamID {
+		return nil
+	}
+	entries := s.entries[s.search(id.next()):]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+func (g *group) consumer(name string, now time.Time) *consumer {
+	cons, ok := g.consumers[name]
+	if !ok {
+		cons = &consumer{}
+		g.consumers[name] = cons
+	}
+	cons.seenAt = now
+	return cons
+}
+
+// pendingIDs returns the pending IDs of the group in order, only those of
+// one consumer if consumerName is set.
+func (g *group) pendingIDs(consumerName string) []streamID {
+	var ids []streamID
+	for id, p := range g.pending {
+		if consumerName == "" || p.consumer == consumerName {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// streamFor returns the stream at key, creating it if create is set. It
+// writes a WRONGTYPE error and returns ok false for a key of another type.
+func (c *conn) streamFor(key string, create bool) (s *stream, ok bool) {
+	it, ok := c.lookupType(key, typeStream)
+	if !ok {
+		return nil, false
+	}
+	if it == nil {
+		if !create {
+			return nil, true
+		}
+		it = &item{typ: typeStream, stream: newStream()}
+		c.keys()[key] = it
+	}
+	return it.stream, true
+}
+
+func (w writer) entry(e streamEntry) {
+	w.array(2)
+	w.bulk(e.id.String())
+	w.bulks(e.fields...)
+}
+
+func (w writer) entries(entries []streamEntry) {
+	w.array(len(entries))
+	for _, e := range entries {
+		w.entry(e)
+	}
+}
+
+// trimOptions are MAXLEN or MINID [=|~] threshold [LIMIT n] of XADD and
+// XTRIM. Trimming is always exact; ~ is accepted and treated as =.
+type trimOptions struct {
+	strategy string // "", "MAXLEN" or "MINID"
+	maxLen   int
+	minID    streamID
+}
+
+// parseTrim parses trimming options starting at args[i] and returns the
+// index after them.
+func (c *conn) parseTrim(args []string, i int) (trimOptions, int, bool) {
+	opts := trimOptions{strategy: strings.ToUpper(args[i])}
+	i++
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		i++
+	}
+	if i >= len(args) {
+		c.w.err(errSyntax)
+		return opts, i, false
+	}
+	switch opts.strategy {
+	case "MAXLEN":
+		n, ok := parseInt(args[i])
+		if !ok || n < 0 {
+			c.w.err("ERR The MAXLEN argument must be >= 0.")
+			return opts, i, false
+		}
+		opts.maxLen = int(n)
+	case "MINID":
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			c.w.err(errInvalidStreamID)
+			return opts, i, false
+		}
+		opts.minID = id
+	}
+	i++
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if _, ok := parseInt(args[i+1]); !ok {
+			c.w.err(errNotInteger)
+			return opts, i, false
+		}
+		i += 2
+	}
+	return opts, i, true
+}
+
+// trim removes entries according to opts and returns how many it removed.
+func (s *stream) trim(opts trimOptions) int {
+	n := 0
+	switch opts.strategy {
+	case "MAXLEN":
+		n = max(len(s.entries)-opts.maxLen, 0)
+	case "MINID":
+		n = s.search(opts.minID)
+	}
+	if n == 0 {
+		return 0
+	}
+	s.entries = append([]streamEntry(nil), s.entries[n:]...)
+	return n
+}
+
+// cmdXAdd implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold
+// [LIMIT n]] *|id field value [field value ...].
+func cmdXAdd(c *conn, args []string) {
+	key, i := args[1], 2
+	noMkStream := false
+	var trim trimOptions
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			continue
+		case "MAXLEN", "MINID":
+			var ok bool
+			if trim, i, ok = c.parseTrim(args, i); !ok {
+				return
+			}
+			i--
+			continue
+		}
+		break
+	}
+	if i >= len(args) || (len(args)-i-1)%2 != 0 || len(args)-i-1 == 0 {
+		c.w.err(errArity(args[0]))
+		return
+	}
+	idArg, fields := args[i], args[i+1:]
+
+	s, ok := c.streamFor(key, false)
+	if !ok {
+		return
+	}
+	if s == nil && noMkStream {
+		c.w.null()
+		return
+	}
+	var last streamID
+	if s != nil {
+		last = s.lastID
+	}
+
+	var id streamID
+	switch {
+	case idArg == "*":
+		ms := uint64(c.srv.now().UnixMilli())
+		id = streamID{ms, 0}
+		if ms <= last.ms {
+			id = last.next()
+		}
+	case strings.HasSuffix(idArg, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+		id = streamID{ms, 0}
+		if ms == last.ms {
+			id = last.next()
+		}
+	default:
+		if id, ok = parseStreamID(idArg, 0); !ok {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+	}
+	if id == (streamID{}) {
+		c.w.err("ERR The ID specified in XADD must be greater than 0-0")
+		return
+	}
+	if !last.less(id) {
+		c.w.err("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		return
+	}
+
+	if s == nil {
+		s, _ = c.streamFor(key, true)
+	}
+	s.entries = append(s.entries, streamEntry{id: id, fields: append([]string(nil), fields...)})
+	s.lastID = id
+	s.trim(trim)
+	c.srv.notify()
+	c.w.bulk(id.String())
+}
+
+func cmdXLen(c *conn, args []string) {
+	s, ok := c.streamFor(args[1], false)
+	if !ok {
+		return
+	}
+	if s == nil {
+		c.w.int(0)
+		return
+	}
+	c.w.int(int64(len(s.entries)))
+}
+
+// rangeBound parses an XRANGE bound: - or +, an ID, or an ID prefixed with
+// ( to exclude it.
+func rangeBound(arg string, start bool) (streamID, bool) {
+	switch arg {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return maxStreamID, true
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	arg = strings.TrimPrefix(arg, "(")
+	defaultSeq := uint64(0)
+	if !start {
+		defaultSeq = math.MaxUint64
+	}
+	id, ok := parseStreamID(arg, defaultSeq)
+	if !ok || !exclusive {
+		return id, ok
+	}
+	if start {
+		if id == maxStreamID {
+			return id, false
+		}
+		return id.next(), true
+	}
+	if id == (streamID{}) {
+		return id, false
+	}
+	if id.seq == 0 {
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return streamID{id.ms, id.seq - 1}, true
+}
+
+// cmdXRange implements XRANGE key start end [COUNT n] and XREVRANGE key end
+// start [COUNT n].
+func cmdXRange(c *conn, args []string) {
+	reverse := strings.ToUpper(args[0]) == "XREVRANGE"
+	startArg, endArg := args[2], args[3]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, ok1 := rangeBound(startArg, true)
+	end, ok2 := rangeBound(endArg, false)
+	if !ok1 || !ok2 {
+		c.w.err(errInvalidStreamID)
+		return
+	}
+	count := -1
+	if len(args) == 6 && strings.ToUpper(args[4]) == "COUNT" {
+		n, ok := parseInt(args[5])
+		if !ok {
+			c.w.err(errNotInteger)
+			return
+		}
+		count = int(max(n, 0))
+	} else if len(args) != 4 {
+		c.w.err(errSyntax)
+		return
+	}
+
+	s, ok := c.streamFor(args[1], false)
+	if !ok {
+		return
+	}
+	var entries []streamEntry
+	if s != nil && !end.less(start) {
+		entries = s.entries[s.search(start):]
+		entries = entries[:sort.Search(len(entries), func(i int) bool { return end.less(entries[i].id) })]
+	}
+	if reverse {
+		reversed := make([]streamEntry, len(entries))
+		for i, e := range entries {
+			reversed[len(entries)-1-i] = e
+		}
+		entries = reversed
+	}
+	if count >= 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	c.w.entries(entries)
+}
+
+func cmdXDel(c *conn, args []string) {
+	s, ok := c.streamFor(args[1], false)
+	if !ok {
+		return
+	}
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+		ids = append(ids, id)
+	}
+	var deleted int64
+	if s != nil {
+		for _, id := range ids {
+			if i := s.search(id); i < len(s.entries) && s.entries[i].id == id {
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				deleted++
+			}
+		}
+	}
+	c.w.int(deleted)
+}
+
+func cmdXTrim(c *conn, args []string) {
+	strategy := strings.ToUpper(args[2])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		c.w.err(errSyntax)
+		return
+	}
+	trim, i, ok := c.parseTrim(args, 2)
+	if !ok {
+		return
+	}
+	if i != len(args) {
+		c.w.err(errSyntax)
+		return
+	}
+	s, ok := c.streamFor(args[1], false)
+	if !ok {
+		return
+	}
+	if s == nil {
+		c.w.int(0)
+		return
+	}
+	c.w.int(int64(s.trim(trim)))
+}
+
+// readRequest is a parsed XREAD or XREADGROUP.
+type readRequest struct {
+	group, consumer string // XREADGROUP only
+	count           int
+	block           time.Duration
+	blocking        bool
+	noAck           bool
+	keys            []string
+	ids             []string
+}
+
+func (c *conn) parseRead(args []string) (readRequest, bool) {
+	var req readRequest
+	isGroup := strings.ToUpper(args[0]) == "XREADGROUP"
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			i++
+			break
+		}
+		switch {
+		case opt == "COUNT" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				c.w.err(errNotInteger)
+				return req, false
+			}
+			req.count = int(max(n, 0))
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok || n < 0 {
+				c.w.err("ERR timeout is not an integer or out of range")
+				return req, false
+			}
+			req.block, req.blocking = time.Duration(n)*time.Millisecond, true
+			i++
+		case opt == "GROUP" && isGroup && i+2 < len(args):
+			req.group, req.consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "NOACK" && isGroup:
+			req.noAck = true
+		default:
+			c.w.err(errSyntax)
+			return req, false
+		}
+	}
+	rest := args[min(i, len(args)):]
+	if i > len(args) || len(rest) == 0 || len(rest)%2 != 0 {
+		c.w.err("ERR Unbalanced '" + strings.ToLower(args[0]) + "' list of streams: for each stream key an ID or '$' must be specified.")
+		return req, false
+	}
+	if isGroup && req.group == "" {
+		c.w.err("ERR Missing GROUP option for XREADGROUP")
+		return req, false
+	}
+	req.keys, req.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+	return req, true
+}
+
+// streamResult is the part of a read reply for one stream. A nil fields
+// slice in a history read marks an entry deleted while pending.
+type streamResult struct {
+	key     string
+	entries []streamEntry
+}
+
+// cmdXRead implements XREAD [COUNT n] [BLOCK ms] STREAMS key ... id ... and
+// XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] [NOACK] STREAMS
+// key ... id .... With BLOCK, the call waits until one of the streams gets
+// new entries; BLOCK 0 waits forever.
+func cmdXRead(c *conn, args []string) {
+	req, ok := c.parseRead(args)
+	if !ok {
+		return
+	}
+
+	// Resolve $ once, so the wait is for entries added after the call.
+	from := make([]streamID, len(req.keys))
+	for i, key := range req.keys {
+		s, ok := c.streamFor(key, false)
+		if !ok {
+			return
+		}
+		switch {
+		case req.ids[i] == "$" && req.group == "":
+			if s != nil {
+				from[i] = s.lastID
+			}
+		case req.ids[i] == ">" && req.group != "":
+		default:
+			id, ok := parseStreamID(req.ids[i], 0)
+			if !ok {
+				c.w.err(errInvalidStreamID)
+				return
+			}
+			from[i] = id
+		}
+		if req.group != "" && (s == nil || s.groups[req.group] == nil) {
+			c.w.err(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, req.group))
+			return
+		}
+	}
+
+	var deadline <-chan time.Time
+	if req.blocking && req.block > 0 {
+		timer := time.NewTimer(req.block)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		results, history, ok := c.read(req, from)
+		if !ok {
+			return
+		}
+		if len(results) > 0 || history {
+			c.w.array(len(results))
+			for _, r := range results {
+				c.w.array(2)
+				c.w.bulk(r.key)
+				c.w.array(len(r.entries))
+				for _, e := range r.entries {
+					if e.fields == nil {
+						c.w.array(2)
+						c.w.bulk(e.id.String())
+						c.w.nullArray()
+						continue
+					}
+					c.w.entry(e)
+				}
+			}
+			return
+		}
+		if !req.blocking || c.inExec {
+			c.w.nullArray()
+			return
+		}
+		if !c.wait(deadline) {
+			c.w.nullArray()
+			return
+		}
+	}
+}
+
+// read collects what a read request returns right now. history is set when
+// an XREADGROUP asked for a consumer's pending entries; such reads reply
+// even when empty.
+func (c *conn) read(req readRequest, from []streamID) (results []streamResult, history bool, ok bool) {
+	now := c.srv.now()
+	for i, key := range req.keys {
+		s, ok := c.streamFor(key, false)
+		if !ok {
+			return nil, false, false
+		}
+		if req.group == "" {
+			if s != nil {
+				if entries := s.after(from[i], req.count); len(entries) > 0 {
+					results = append(results, streamResult{key, entries})
+				}
+			}
+			continue
+		}
+
+		var g *group
+		if s != nil {
+			g = s.groups[req.group]
+		}
+		if g == nil {
+			c.w.err(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, req.group))
+			return nil, false, false
+		}
+		g.consumer(req.consumer, now)
+
+		if req.ids[i] != ">" {
+			// History: the consumer's pending entries after the ID. As in
+			// Redis, reading one again counts as another delivery.
+			history = true
+			var entries []streamEntry
+			for _, id := range g.pendingIDs(req.consumer) {
+				if !from[i].less(id) {
+					continue
+				}
+				p := g.pending[id]
+				p.deliveredAt = now
+				p.deliveries++
+				e, found := s.entry(id)
+				if !found {
+					e = streamEntry{id: id}
+				}
+				entries = append(entries, e)
+				if req.count > 0 && len(entries) == req.count {
+					break
+				}
+			}
+			results = append(results, streamResult{key, entries})
+			continue
+		}
+
+		entries := s.after(g.lastID, req.count)
+		if len(entries) == 0 {
+			continue
+		}
+		g.lastID = entries[len(entries)-1].id
+		if !req.noAck {
+			for _, e := range entries {
+				g.pending[e.id] = &pendingEntry{consumer: req.consumer, deliveredAt: now, deliveries: 1}
+			}
+		}
+		results = append(results, streamResult{key, entries})
+	}
+	return results, history, true
+}
+
+// wait releases the keyspace lock until a stream changes, the deadline
+// passes, the client hangs up or the server closes. It returns false unless
+// a stream changed. The caller holds srv.mu.
+func (c *conn) wait(deadline <-chan time.Time) bool {
+	changed := c.srv.changed
+	c.srv.mu.Unlock()
+	defer c.srv.mu.Lock()
+
+	hangup, stop := c.watchHangup()
+	defer stop()
+	select {
+	case <-changed:
+		return true
+	case <-deadline:
+	case <-hangup:
+	case <-c.srv.done:
+	}
+	return false
+}
+
+// watchHangup notices a client that disconnects while its command blocks.
+// The returned channel is closed on hangup; stop must be called before the
+// connection is read again.
+func (c *conn) watchHangup() (<-chan struct{}, func()) {
+	hangup := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		_, err := c.r.Peek(1)
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+			close(hangup)
+		}
+	}()
+	return hangup, func() {
+		// Interrupt the Peek; data it already read stays buffered.
+		c.nc.SetReadDeadline(time.Unix(1, 0))
+		<-exited
+		c.nc.SetReadDeadline(time.Time{})
+	}
+}
+
+// groupFor returns the group of the stream at key, writing a NOGROUP error
+// if either does not exist.
+func (c *conn) groupFor(key, name string) (*stream, *group, bool) {
+	s, ok := c.streamFor(key, false)
+	if !ok {
+		return nil, nil, false
+	}
+	if s == nil || s.groups[name] == nil {
+		c.w.err(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name))
+		return nil, nil, false
+	}
+	return s, s.groups[name], true
+}
+
+// groupStartID parses the ID a group starts after: an ID, 0, or $ for the
+// end of the stream.
+func groupStartID(arg string, s *stream) (streamID, bool) {
+	if arg == "$" {
+		if s == nil {
+			return streamID{}, true
+		}
+		return s.lastID, true
+	}
+	return parseStreamID(arg, 0)
+}
+
+// cmdXGroup implements the XGROUP subcommands CREATE, SETID, DESTROY,
+// CREATECONSUMER and DELCONSUMER.
+func cmdXGroup(c *conn, args []string) {
+	sub := strings.ToUpper(args[1])
+	switch {
+	case sub == "CREATE" && len(args) >= 5:
+		key, name := args[2], args[3]
+		mkStream := false
+		for i := 5; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "MKSTREAM":
+				mkStream = true
+			case "ENTRIESREAD":
+				i++
+			default:
+				c.w.err(errSyntax)
+				return
+			}
+		}
+		s, ok := c.streamFor(key, false)
+		if !ok {
+			return
+		}
+		start, ok := groupStartID(args[4], s)
+		if !ok {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+		if s == nil {
+			if !mkStream {
+				c.w.err("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+				return
+			}
+			s, _ = c.streamFor(key, true)
+		}
+		if s.groups[name] != nil {
+			c.w.err("BUSYGROUP Consumer Group name already exists")
+			return
+		}
+		s.groups[name] = &group{lastID: start, pending: make(map[streamID]*pendingEntry), consumers: make(map[string]*consumer)}
+		c.w.ok()
+
+	case sub == "SETID" && len(args) >= 5:
+		s, g, ok := c.groupFor(args[2], args[3])
+		if !ok {
+			return
+		}
+		start, ok := groupStartID(args[4], s)
+		if !ok {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+		g.lastID = start
+		c.w.ok()
+
+	case sub == "DESTROY" && len(args) == 4:
+		s, ok := c.streamFor(args[2], false)
+		if !ok {
+			return
+		}
+		if s == nil || s.groups[args[3]] == nil {
+			c.w.int(0)
+			return
+		}
+		delete(s.groups, args[3])
+		c.srv.notify() // fail readers blocked on the group
+		c.w.int(1)
+
+	case sub == "CREATECONSUMER" && len(args) == 5:
+		_, g, ok := c.groupFor(args[2], args[3])
+		if !ok {
+			return
+		}
+		if g.consumers[args[4]] != nil {
+			c.w.int(0)
+			return
+		}
+		g.consumer(args[4], c.srv.now())
+		c.w.int(1)
+
+	case sub == "DELCONSUMER" && len(args) == 5:
+		_, g, ok := c.groupFor(args[2], args[3])
+		if !ok {
+			return
+		}
+		var dropped int64
+		for id, p := range g.pending {
+			if p.consumer == args[4] {
+				delete(g.pending, id)
+				dropped++
+			}
+		}
+		delete(g.consumers, args[4])
+		c.w.int(dropped)
+
+	default:
+		c.w.err(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", args[1]))
+	}
+}
+
+func cmdXAck(c *conn, args []string) {
+	ids := make([]streamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			c.w.err(errInvalidStreamID)
+			return
+		}
+		ids = append(ids, id)
+	}
+	s, ok := c.streamFor(args[1], false)
+	if !ok {
+		return
+	}
+	var acked int64
+	if s != nil && s.groups[args[2]] != nil {
+		g := s.groups[args[2]]
+		for _, id := range ids {
+			if g.pending[id] != nil {
+				delete(g.pending, id)
+				acked++
+			}
+		}
+	}
+	c.w.int(acked)
+}
+
+// cmdXPending implements the summary form XPENDING key group and the
+// extended form XPENDING key group [IDLE ms] start end count [consumer].
+func cmdXPending(c *conn, args []string) {
+	_, g, ok := c.groupFor(args[1], args[2])
+	if !ok {
+		return
+	}
+	now := c.srv.now()
+
+	if len(args) == 3 {
+		ids := g.pendingIDs("")
+		if len(ids) == 0 {
+			c.w.array(4)
+			c.w.int(0)
+			c.w.null()
+			c.w.null()
+			c.w.nullArray()
+			return
+		}
+		perConsumer := make(map[string]int)
+		for _, p := range g.pending {
+			perConsumer[p.consumer]++
+		}
+		names := make([]string, 0, len(perConsumer))
+		for name := range perConsumer {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.w.array(4)
+		c.w.int(int64(len(ids)))
+		c.w.bulk(ids[0].String())
+		c.w.bulk(ids[len(ids)-1].String())
+		c.w.array(len(names))
+		for _, name := range names {
+			c.w.bulks(name, strconv.Itoa(perConsumer[name]))
+		}
+		return
+	}
+
+	rest := args[3:]
+	var minIdle time.Duration
+	if len(rest) > 0 && strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			c.w.err(errSyntax)
+			return
+		}
+		n, ok := parseInt(rest[1])
+		if !ok {
+			c.w.err(errNotInteger)
+			return
+		}
+		minIdle = time.Duration(n) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		c.w.err(errSyntax)
+		return
+	}
+	start, ok1 := rangeBound(rest[0], true)
+	end, ok2 := rangeBound(rest[1], false)
+	if !ok1 || !ok2 {
+		c.w.err(errInvalidStreamID)
+		return
+	}
+	count, ok := parseInt(rest[2])
+	if !ok {
+		c.w.err(errNotInteger)
+		return
+	}
+	consumerName := ""
+	if len(rest) == 4 {
+		consumerName = rest[3]
+	}
+
+	type row struct {
+		id streamID
+		p  *pendingEntry
+	}
+	var rows []row
+	for _, id := range g.pendingIDs(consumerName) {
+		p := g.pending[id]
+		if id.less(start) || end.less(id) || now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+		if int64(len(rows)) >= count {
+			break
+		}
+		rows = append(rows, row{id, p})
+	}
+	c.w.array(len(rows))
+	for _, r := range rows {
+		c.w.array(4)
+		c.w.bulk(r.id.String())
+		c.w.bulk(r.p.consumer)
+		c.w.int(now.Sub(r.p.deliveredAt).Milliseconds())
+		c.w.int(r.p.deliveries)
+	}
+}
+
+// cmdXClaim implements XCLAIM key group consumer min-idle-time id ...
+// [IDLE ms] [TIME ms] [RETRYCOUNT n] [FORCE] [JUSTID] [LASTID id].
+func cmdXClaim(c *conn, args []string) {
+	s, g, ok := c.groupFor(args[1], args[2])
+	if !ok {
+		return
+	}
+	consumerName := args[3]
+	minIdleMs, ok := parseInt(args[4])
+	if !ok {
+		c.w.err("ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+	now := c.srv.now()
+	var ids []streamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	deliveredAt, retryCount := now, int64(-1)
+	force, justID := false, false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT" || opt == "LASTID") && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok && opt != "LASTID" {
+				c.w.err(errNotInteger)
+				return
+			}
+			switch opt {
+			case "IDLE":
+				deliveredAt = now.Add(-time.Duration(n) * time.Millisecond)
+			case "TIME":
+				deliveredAt = time.UnixMilli(n)
+			case "RETRYCOUNT":
+				retryCount = n
+			}
+			i++
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		default:
+			c.w.err(errSyntax)
+			return
+		}
+	}
+
+	g.consumer(consumerName, now)
+	var claimed []streamEntry
+	for _, id := range ids {
+		e, exists := s.entry(id)
+		p := g.pending[id]
+		if p == nil {
+			if !force || !exists {
+				continue
+			}
+			p = &pendingEntry{}
+			g.pending[id] = p
+		}
+		if now.Sub(p.deliveredAt) < time.Duration(minIdleMs)*time.Millisecond {
+			continue
+		}
+		if !exists {
+			// The entry was deleted; drop it from the pending list.
+			delete(g.pending, id)
+			continue
+		}
+		p.consumer, p.deliveredAt = consumerName, deliveredAt
+		switch {
+		case retryCount >= 0:
+			p.deliveries = retryCount
+		case !justID:
+			p.deliveries++
+		}
+		claimed = append(claimed, e)
+	}
+	if justID {
+		c.w.array(len(claimed))
+		for _, e := range claimed {
+			c.w.bulk(e.id.String())
+		}
+		return
+	}
+	c.w.entries(claimed)
+}
@@ -0,0 +1,161 @@
+package engine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestConsumerGroupV8(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	rdb := newV8(t, srv)
+
+	if err := rdb.XGroupCreateMkStream(ctx, "orders", "billing", "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, item := range []string{"book", "lamp", "pen"} {
+		id, err := rdb.XAdd(ctx, &redisv8.XAddArgs{Stream: "orders", Values: []string{"item", item}}).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	read := func(consumer string, count int64) []redisv8.XMessage {
+		t.Helper()
+		streams, err := rdb.XReadGroup(ctx, &redisv8.XReadGroupArgs{
+			Group:    "billing",
+			Consumer: consumer,
+			Streams:  []string{"orders", ">"},
+			Count:    count,
+			Block:    -1,
+		}).Result()
+		if errors.Is(err, redisv8.Nil) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return streams[0].Messages
+	}
+	a := read("a", 2)
+	b := read("b", 0)
+	if len(a) != 2 || a[0].ID != ids[0] || a[1].ID != ids[1] || a[0].Values["item"] != "book" {
+		t.Fatalf("consumer a read %v, want the first two orders", a)
+	}
+	if len(b) != 1 || b[0].ID != ids[2] {
+		t.Fatalf("consumer b read %v, want the third order", b)
+	}
+	if rest := read("a", 0); len(rest) != 0 {
+		t.Errorf("read after every entry was delivered = %v, want none", rest)
+	}
+
+	if n := rdb.XAck(ctx, "orders", "billing", ids[0]).Val(); n != 1 {
+		t.Errorf("XACK = %d, want 1", n)
+	}
+	if n := rdb.XAck(ctx, "orders", "billing", ids[0]).Val(); n != 0 {
+		t.Errorf("second XACK of the same entry = %d, want 0", n)
+	}
+
+	summary, err := rdb.XPending(ctx, "orders", "billing").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 2 || summary.Lower != ids[1] || summary.Higher != ids[2] ||
+		summary.Consumers["a"] != 1 || summary.Consumers["b"] != 1 {
+		t.Errorf("XPENDING summary = %+v", summary)
+	}
+
+	srv.Advance(time.Minute)
+	pending, err := rdb.XPendingExt(ctx, &redisv8.XPendingExtArgs{
+		Stream: "orders", Group: "billing", Start: "-", End: "+", Count: 10, Consumer: "a",
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != ids[1] || pending[0].RetryCount != 1 || pending[0].Idle < time.Minute {
+		t.Errorf("XPENDING for consumer a = %+v", pending)
+	}
+}
+
+func TestConsumerGroupV9(t *testing.T) {
+	ctx := context.Background()
+	srv := start(t)
+	rdb := newV9(t, srv)
+
+	if err := rdb.XGroupCreateMkStream(ctx, "events", "workers", "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.XGroupCreate(ctx, "events", "workers", "0").Err(); err == nil || err.Error() != "BUSYGROUP Consumer Group name already exists" {
+		t.Errorf("creating the group twice: err = %v, want BUSYGROUP", err)
+	}
+
+	// A blocked XREADGROUP returns once an entry is added.
+	got := make(chan []redis.XStream, 1)
+	go func() {
+		streams, _ := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    "workers",
+			Consumer: "w1",
+			Streams:  []string{"events", ">"},
+			Block:    5 * time.Second,
+		}).Result()
+		got <- streams
+	}()
+	time.Sleep(50 * time.Millisecond)
+	id, err := rdb.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"kind": "signup"}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streams []redis.XStream
+	select {
+	case streams = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked XREADGROUP did not return after XADD")
+	}
+	if len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != id ||
+		streams[0].Messages[0].Values["kind"] != "signup" {
+		t.Fatalf("XREADGROUP = %+v, want the new entry", streams)
+	}
+
+	// Reading from 0 again returns the consumer's own pending entries.
+	again, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "w1", Streams: []string{"events", "0"},
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again[0].Messages) != 1 || again[0].Messages[0].ID != id {
+		t.Errorf("XREADGROUP from 0 = %+v, want the pending entry", again)
+	}
+
+	srv.Advance(10 * time.Second)
+	pending, err := rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: "events", Group: "workers", Idle: 5 * time.Second, Start: "-", End: "+", Count: 10,
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Consumer != "w1" || pending[0].RetryCount != 2 {
+		t.Errorf("XPENDING IDLE 5s = %+v, want the entry delivered twice to w1", pending)
+	}
+
+	if err := rdb.XAck(ctx, "events", "workers", id).Err(); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := rdb.XPending(ctx, "events", "workers").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 0 || len(summary.Consumers) != 0 {
+		t.Errorf("XPENDING after XACK = %+v, want nothing pending", summary)
+	}
+	if n := rdb.XLen(ctx, "events").Val(); n != 1 {
+		t.Errorf("XLEN = %d, want 1; acknowledging does not delete", n)
+	}
+}
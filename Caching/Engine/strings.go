@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *conn) setString(key, value string, expiresAt time.Time) {
+	c.keys()[key] = &item{typ: typeString, str: value, expiresAt: expiresAt}
+}
+
+func cmdGet(c *conn, args []string) {
+	it, ok := c.lookupType(args[1], typeString)
+	if !ok {
+		return
+	}
+	if it == nil {
+		c.w.null()
+		return
+	}
+	c.w.bulk(it.str)
+}
+
+// cmdSet implements SET key value [NX|XX] [GET] [EX s|PX ms|EXAT ts|PXAT ts|KEEPTTL].
+func cmdSet(c *conn, args []string) {
+	key, value := args[1], args[2]
+	var nx, xx, get, keepTTL, hasTTL bool
+	var expiresAt time.Time
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTL || i+1 == len(args) {
+				c.w.err(errSyntax)
+				return
+			}
+			unit := time.Second
+			if opt[0] == 'P' {
+				unit = time.Millisecond
+			}
+			var ok bool
+			if expiresAt, ok = c.ttlArg(args[i+1], unit, strings.HasSuffix(opt, "AT")); !ok {
+				return
+			}
+			hasTTL = true
+			i++
+		default:
+			c.w.err(errSyntax)
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && hasTTL) {
+		c.w.err(errSyntax)
+		return
+	}
+
+	old := c.lookup(key)
+	if get && old != nil && old.typ != typeString {
+		c.w.err(errWrongType)
+		return
+	}
+	if (nx && old != nil) || (xx && old == nil) {
+		if get && old != nil {
+			c.w.bulk(old.str)
+		} else {
+			c.w.null()
+		}
+		return
+	}
+	if keepTTL && old != nil {
+		expiresAt = old.expiresAt
+	}
+	c.setString(key, value, expiresAt)
+	switch {
+	case !get:
+		c.w.ok()
+	case old == nil:
+		c.w.null()
+	default:
+		c.w.bulk(old.str)
+	}
+}
+
+func cmdSetNX(c *conn, args []string) {
+	if c.lookup(args[1]) != nil {
+		c.w.int(0)
+		return
+	}
+	c.setString(args[1], args[2], time.Time{})
+	c.w.int(1)
+}
+
+// cmdSetEX implements SETEX key seconds value and PSETEX key ms value.
+func cmdSetEX(c *conn, args []string) {
+	unit := time.Second
+	if strings.ToUpper(args[0]) == "PSETEX" {
+		unit = time.Millisecond
+	}
+	expiresAt, ok := c.ttlArg(args[2], unit, false)
+	if !ok {
+		return
+	}
+	c.setString(args[1], args[3], expiresAt)
+	c.w.ok()
+}
+
+func cmdGetDel(c *conn, args []string) {
+	it, ok := c.lookupType(args[1], typeString)
+	if !ok {
+		return
+	}
+	if it == nil {
+		c.w.null()
+		return
+	}
+	delete(c.keys(), args[1])
+	c.w.bulk(it.str)
+}
+
+func cmdMGet(c *conn, args []string) {
+	c.w.array(len(args) - 1)
+	for _, key := range args[1:] {
+		// MGET reports keys of other types as missing instead of failing.
+		if it := c.lookup(key); it != nil && it.typ == typeString {
+			c.w.bulk(it.str)
+		} else {
+			c.w.null()
+		}
+	}
+}
+
+func cmdMSet(c *conn, args []string) {
+	if len(args)%2 == 0 {
+		c.w.err(errArity(args[0]))
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		c.setString(args[i], args[i+1], time.Time{})
+	}
+	c.w.ok()
+}
+
+// cmdIncr implements INCR, DECR, INCRBY and DECRBY. The TTL of the key is
+// kept.
+func cmdIncr(c *conn, args []string) {
+	name := strings.ToUpper(args[0])
+	delta := int64(1)
+	if len(args) == 3 {
+		var ok bool
+		if delta, ok = parseInt(args[2]); !ok {
+			c.w.err(errNotInteger)
+			return
+		}
+	}
+	if strings.HasPrefix(name, "DECR") {
+		if delta == math.MinInt64 {
+			c.w.err("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	it, ok := c.lookupType(args[1], typeString)
+	if !ok {
+		return
+	}
+	var n int64
+	if it != nil {
+		if n, ok = parseInt(it.str); !ok {
+			c.w.err(errNotInteger)
+			return
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		c.w.err("ERR increment or decrement would overflow")
+		return
+	}
+	n += delta
+	if it == nil {
+		c.setString(args[1], strconv.FormatInt(n, 10), time.Time{})
+	} else {
+		it.str = strconv.FormatInt(n, 10)
+	}
+	c.w.int(n)
+}
+
+func cmdAppend(c *conn, args []string) {
+	it, ok := c.lookupType(args[1], typeString)
+	if !ok {
+		return
+	}
+	if it == nil {
+		c.setString(args[1], args[2], time.Time{})
+		c.w.int(int64(len(args[2])))
+		return
+	}
+	it.str += args[2]
+	c.w.int(int64(len(it.str)))
+}
+
+func cmdStrlen(c *conn, args []string) {
+	it, ok := c.lookupType(args[1], typeString)
+	if !ok {
+		return
+	}
+	if it == nil {
+		c.w.int(0)
+		return
+	}
+	c.w.int(int64(len(it.str)))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Write modes for /set and /mset.
+const (
+	setAlways      = ""
+	setIfNotExists = "nx"
+	setIfExists    = "xx"
+)
+
+// noExpiry is the TTL reported for a key that is kept until deleted.
+const noExpiry = time.Duration(-1)
+
+var errKeyNotFound = errors.New("key not found")
+
+// setOptions are the query parameters of /set and /mset. They apply to every
+// key of the request.
+type setOptions struct {
+	ttl  time.Duration // 0 means the keys never expire
+	mode string
+}
+
+// keyStatus is the outcome of writing one key.
+type keyStatus struct {
+	Status string `json:"status"` // "ok", "not_set" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// entry is the result of reading one key. Found tells a missing key apart
+// from a stored empty string.
+type entry struct {
+	Found bool
+	Value string
+	TTL   time.Duration // noExpiry for a key without one
+}
+
+// Backend is the cache the gateway fronts. The HTTP handlers only talk to
+// this interface, so every backend gets the same endpoints and semantics.
+type Backend interface {
+	// Name is used in log and error messages, e.g. "Redis".
+	Name() string
+	Ping(ctx context.Context) error
+	// Set writes every pair and reports the result per key. With NX or XX a
+	// key whose condition does not hold is reported as not_set.
+	Set(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus
+	// Get reads every key together with its remaining time to live.
+	Get(ctx context.Context, keys []string) (map[string]entry, error)
+	// Delete removes the keys and returns how many of them existed.
+	Delete(ctx context.Context, keys []string) (int64, error)
+	Exists(ctx context.Context, keys []string) (map[string]bool, error)
+	// TTL returns the remaining time to live of key, noExpiry for a key
+	// without one, or errKeyNotFound.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Expire sets the time to live of an existing key, or removes it when
+	// ttl is 0. It returns errKeyNotFound for a missing key.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	Close() error
+}
+
+// Supported values of -backend.
+const (
+	backendRedis     = "redis"
+	backendDragonfly = "dragonfly"
+	backendMemory    = "memory"
+)
+
+func newBackend(cfg *Config) (Backend, error) {
+	switch cfg.Backend {
+	case backendRedis:
+		if cfg.Shards != "" {
+			return newShardedBackend("Redis", cfg), nil
+		}
+		return newRedisBackend("Redis", cfg), nil
+	case backendDragonfly:
+		// Dragonfly speaks the Redis protocol, so the same client works.
+		if cfg.Shards != "" {
+			return newShardedBackend("DragonflyDB", cfg), nil
+		}
+		return newRedisBackend("DragonflyDB", cfg), nil
+	case backendMemory:
+		return newMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, expected %s, %s or %s", cfg.Backend, backendRedis, backendDragonfly, backendMemory)
+	}
+}
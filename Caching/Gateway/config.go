@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the gateway settings. Every flag can also be set with the
+// environment variable named in its usage string, which is what container
+// deployments use; an explicit flag wins over the environment.
+type Config struct {
+	Listen string
+
+	// HTTP server limits. RequestTimeout bounds the backend calls of one
+	// request and must leave room within HTTPWriteTimeout to write the
+	// response.
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	RequestTimeout   time.Duration
+	// On SIGTERM the gateway fails /readyz for DrainDelay, so load balancers
+	// stop sending traffic, then waits up to ShutdownTimeout for in-flight
+	// requests.
+	DrainDelay      time.Duration
+	ShutdownTimeout time.Duration
+
+	Backend  string
+	Addr     string
+	Password string
+	DB       int
+	PoolSize int
+
+	// Topology of a redis or dragonfly backend. Addr lists the seed nodes
+	// of a cluster or the sentinels, separated by commas.
+	Topology         string
+	MasterName       string
+	SentinelPassword string
+	ReadReplicas     bool
+	MaxRetries       int
+
+	// Shards lists independent nodes to spread keys over with a consistent
+	// hash ring, each with VNodes points on the ring.
+	Shards string
+	VNodes int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// L1 is the optional in-process cache in front of the backend.
+	L1Policy   string
+	L1MaxKeys  int
+	L1MaxBytes int64
+	L1TTL      time.Duration
+	L1Channel  string
+
+	Verbose bool
+}
+
+func parseConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	var errs []error
+	env := func(name, def string) string {
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
+	}
+	envInt := func(name string, def int) int {
+		v, err := strconv.Atoi(env(name, strconv.Itoa(def)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", name, err))
+		}
+		return v
+	}
+	envDuration := func(name string, def time.Duration) time.Duration {
+		v, err := time.ParseDuration(env(name, def.String()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", name, err))
+		}
+		return v
+	}
+	envBool := func(name string, def bool) bool {
+		v, err := strconv.ParseBool(env(name, strconv.FormatBool(def)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", name, err))
+		}
+		return v
+	}
+
+	fs.StringVar(&cfg.Listen, "listen", env("GATEWAY_LISTEN", ":8080"), "HTTP listen address (GATEWAY_LISTEN)")
+	fs.DurationVar(&cfg.HTTPReadTimeout, "http-read-timeout", envDuration("GATEWAY_READ_TIMEOUT", 10*time.Second), "time to read a request, including its body (GATEWAY_READ_TIMEOUT)")
+	fs.DurationVar(&cfg.HTTPWriteTimeout, "http-write-timeout", envDuration("GATEWAY_WRITE_TIMEOUT", 15*time.Second), "time from the end of the request headers to the end of the response (GATEWAY_WRITE_TIMEOUT)")
+	fs.DurationVar(&cfg.HTTPIdleTimeout, "http-idle-timeout", envDuration("GATEWAY_IDLE_TIMEOUT", 60*time.Second), "how long an idle keep-alive connection stays open (GATEWAY_IDLE_TIMEOUT)")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", envDuration("GATEWAY_REQUEST_TIMEOUT", 5*time.Second), "deadline for the backend calls of one request (GATEWAY_REQUEST_TIMEOUT)")
+	fs.DurationVar(&cfg.DrainDelay, "drain-delay", envDuration("GATEWAY_DRAIN_DELAY", 5*time.Second), "how long /readyz fails before the server stops accepting connections on SIGTERM (GATEWAY_DRAIN_DELAY)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", envDuration("GATEWAY_SHUTDOWN_TIMEOUT", 30*time.Second), "how long to wait for in-flight requests on SIGTERM (GATEWAY_SHUTDOWN_TIMEOUT)")
+	fs.StringVar(&cfg.Backend, "backend", env("CACHE_BACKEND", backendRedis), "cache backend: redis, dragonfly or memory (CACHE_BACKEND)")
+	fs.StringVar(&cfg.Addr, "addr", env("CACHE_ADDR", "localhost:6379"), "address of the Redis or Dragonfly server, or comma-separated cluster seed nodes or sentinels (CACHE_ADDR)")
+	fs.StringVar(&cfg.Password, "password", env("CACHE_PASSWORD", ""), "password of the Redis or Dragonfly server (CACHE_PASSWORD)")
+	fs.IntVar(&cfg.DB, "db", envInt("CACHE_DB", 0), "database number (CACHE_DB)")
+	fs.IntVar(&cfg.PoolSize, "pool-size", envInt("CACHE_POOL_SIZE", 1000), "maximum connections to the backend (CACHE_POOL_SIZE)")
+	fs.StringVar(&cfg.Topology, "topology", env("CACHE_TOPOLOGY", topologySingle), "single, cluster or sentinel (CACHE_TOPOLOGY)")
+	fs.StringVar(&cfg.MasterName, "master-name", env("CACHE_MASTER_NAME", ""), "name of the master monitored by the sentinels (CACHE_MASTER_NAME)")
+	fs.StringVar(&cfg.SentinelPassword, "sentinel-password", env("CACHE_SENTINEL_PASSWORD", ""), "password of the sentinels (CACHE_SENTINEL_PASSWORD)")
+	fs.BoolVar(&cfg.ReadReplicas, "read-replicas", envBool("CACHE_READ_REPLICAS", false), "send reads to replicas: the closest node of each cluster shard, or a sentinel-monitored replica (CACHE_READ_REPLICAS)")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", envInt("CACHE_MAX_RETRIES", 3), "retries of a failed backend command, e.g. while a failover completes (CACHE_MAX_RETRIES)")
+	fs.StringVar(&cfg.Shards, "shards", env("CACHE_SHARDS", ""), "comma-separated independent nodes to shard keys over with consistent hashing; replaces -addr (CACHE_SHARDS)")
+	fs.IntVar(&cfg.VNodes, "vnodes", envInt("CACHE_VNODES", 160), "points per shard on the hash ring (CACHE_VNODES)")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", envDuration("CACHE_DIAL_TIMEOUT", 5*time.Second), "timeout for connecting to the backend (CACHE_DIAL_TIMEOUT)")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", envDuration("CACHE_READ_TIMEOUT", 3*time.Second), "timeout for backend replies (CACHE_READ_TIMEOUT)")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", envDuration("CACHE_WRITE_TIMEOUT", 3*time.Second), "timeout for backend writes (CACHE_WRITE_TIMEOUT)")
+	fs.StringVar(&cfg.L1Policy, "l1", env("L1_POLICY", l1Off), "in-process L1 cache in front of the backend: off, lru or lfu (L1_POLICY)")
+	fs.IntVar(&cfg.L1MaxKeys, "l1-max-keys", envInt("L1_MAX_KEYS", 10000), "maximum keys in the L1 cache (L1_MAX_KEYS)")
+	fs.Int64Var(&cfg.L1MaxBytes, "l1-max-bytes", int64(envInt("L1_MAX_BYTES", 64<<20)), "maximum key and value bytes in the L1 cache, 0 for no limit (L1_MAX_BYTES)")
+	fs.DurationVar(&cfg.L1TTL, "l1-ttl", envDuration("L1_TTL", 10*time.Second), "longest time the L1 cache serves a key without asking the backend (L1_TTL)")
+	fs.StringVar(&cfg.L1Channel, "l1-channel", env("L1_CHANNEL", "cache-gateway:invalidate"), "pub/sub channel the gateways use to invalidate each other's L1 cache (L1_CHANNEL)")
+	fs.BoolVar(&cfg.Verbose, "verbose", envBool("GATEWAY_VERBOSE", false), "log every key read and written (GATEWAY_VERBOSE)")
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if cfg.HTTPReadTimeout <= 0 || cfg.HTTPWriteTimeout <= 0 || cfg.HTTPIdleTimeout <= 0 || cfg.ShutdownTimeout <= 0 || cfg.DrainDelay < 0 {
+		return nil, fmt.Errorf("http timeouts and shutdown-timeout must be positive and drain-delay non-negative")
+	}
+	if cfg.RequestTimeout <= 0 || cfg.RequestTimeout >= cfg.HTTPWriteTimeout {
+		return nil, fmt.Errorf("request-timeout must be positive and shorter than http-write-timeout, got %v and %v", cfg.RequestTimeout, cfg.HTTPWriteTimeout)
+	}
+	if cfg.PoolSize <= 0 {
+		return nil, fmt.Errorf("pool-size must be positive, got %d", cfg.PoolSize)
+	}
+	switch cfg.Topology {
+	case topologySingle:
+		if strings.Contains(cfg.Addr, ",") {
+			return nil, fmt.Errorf("topology single takes one address, got %q", cfg.Addr)
+		}
+	case topologyCluster:
+	case topologySentinel:
+		if cfg.MasterName == "" {
+			return nil, fmt.Errorf("topology sentinel needs -master-name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown topology %q, expected %s, %s or %s", cfg.Topology, topologySingle, topologyCluster, topologySentinel)
+	}
+	if cfg.Shards != "" {
+		if cfg.Backend == backendMemory || cfg.Topology != topologySingle {
+			return nil, fmt.Errorf("shards need a redis or dragonfly backend with topology single")
+		}
+		if cfg.VNodes <= 0 {
+			return nil, fmt.Errorf("vnodes must be positive, got %d", cfg.VNodes)
+		}
+		seen := make(map[string]bool)
+		for _, node := range strings.Split(cfg.Shards, ",") {
+			if node == "" || seen[node] {
+				return nil, fmt.Errorf("shards must be distinct non-empty addresses, got %q", cfg.Shards)
+			}
+			seen[node] = true
+		}
+	}
+	switch cfg.L1Policy {
+	case l1Off:
+	case l1LRU, l1LFU:
+		if cfg.L1MaxKeys <= 0 || cfg.L1MaxBytes < 0 || cfg.L1TTL <= 0 {
+			return nil, fmt.Errorf("l1 needs a positive l1-max-keys and l1-ttl and a non-negative l1-max-bytes")
+		}
+	default:
+		return nil, fmt.Errorf("unknown l1 policy %q, expected %s, %s or %s", cfg.L1Policy, l1Off, l1LRU, l1LFU)
+	}
+	return cfg, nil
+}
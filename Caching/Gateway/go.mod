@@ -0,0 +1,10 @@
+module Gateway
+
+go 1.23.4
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/go-redis/redis/v8 v8.11.5
+)
+
+require github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
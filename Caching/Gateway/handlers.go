@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes returned in the "code" field of every error response, so
+// clients can branch on them without parsing messages.
+const (
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInvalidRequest   = "invalid_request"
+	codeNotFound         = "not_found"
+	codeConditionFailed  = "condition_failed"
+	codeBackendError     = "backend_error"
+	codeTimeout          = "timeout"
+)
+
+// errorResponse is the body of every non-2xx response.
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// keyResult is the read result for one key. TTL is in seconds, -1 for a key
+// without an expiry; value and ttl are left out for a missing key.
+type keyResult struct {
+	Found bool     `json:"found"`
+	Value *string  `json:"value,omitempty"`
+	TTL   *float64 `json:"ttl,omitempty"`
+}
+
+// resultsResponse wraps the per-key results of multi-key endpoints.
+type resultsResponse[T any] struct {
+	Results map[string]T `json:"results"`
+}
+
+// debugf logs per-key activity when the gateway runs with -verbose. Failures
+// are always logged with log.Printf.
+func debugf(format string, args ...interface{}) {
+	if cfg.Verbose {
+		log.Printf(format, args...)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeBackendError reports a failed backend call: a 504 if the request ran
+// out of time, a 500 otherwise. The client may see the deadline as a network
+// timeout rather than a context error, so the request context is checked
+// too.
+func writeBackendError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, codeTimeout, fmt.Sprintf("%s: no answer within %v", message, cfg.RequestTimeout))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, codeBackendError, message)
+}
+
+func ttlSeconds(ttl time.Duration) float64 {
+	if ttl == noExpiry {
+		return -1
+	}
+	return ttl.Seconds()
+}
+
+// batchStatus is 200 when every key succeeded, 207 when only some did and
+// 500 when none did.
+func batchStatus(result map[string]keyStatus) int {
+	failed := 0
+	for _, status := range result {
+		if status.Status == "error" {
+			failed++
+		}
+	}
+	switch failed {
+	case 0:
+		return http.StatusOK
+	case len(result):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+// parseTTL reads a TTL given as a Go duration ("30s", "1h") or as a number
+// of seconds.
+func parseTTL(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func parseSetOptions(r *http.Request) (setOptions, error) {
+	var opts setOptions
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := parseTTL(ttl)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid ttl %q", ttl)
+		}
+		opts.ttl = d
+	}
+	switch mode := strings.ToLower(r.URL.Query().Get("mode")); mode {
+	case setAlways, setIfNotExists, setIfExists:
+		opts.mode = mode
+	default:
+		return opts, fmt.Errorf("invalid mode %q, expected nx or xx", mode)
+	}
+	return opts, nil
+}
+
+// allowMethods writes a 405 and returns false unless r uses one of methods.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, fmt.Sprintf("Method %s is not allowed, use %s", r.Method, strings.Join(methods, " or ")))
+	return false
+}
+
+func decodeSetRequest(w http.ResponseWriter, r *http.Request) (map[string]string, setOptions, bool) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return nil, setOptions{}, false
+	}
+
+	opts, err := parseSetOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return nil, opts, false
+	}
+
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		log.Printf("Failed to decode request body: %v\n", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must be a JSON object of string values")
+		return nil, opts, false
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Request body must contain at least one key")
+		return nil, opts, false
+	}
+	debugf("Setting keys: %v\n", data)
+	return data, opts, true
+}
+
+// decodeKeys returns the key query parameters of a request with one of
+// methods.
+func decodeKeys(w http.ResponseWriter, r *http.Request, methods ...string) ([]string, bool) {
+	if !allowMethods(w, r, methods...) {
+		return nil, false
+	}
+
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Key parameter is required")
+		return nil, false
+	}
+
+	debugf("Received %s request for keys: %v\n", r.URL.Path, keys)
+	return keys, true
+}
+
+// setKeys writes data to the backend and logs the keys that failed.
+func setKeys(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus {
+	result := backend.Set(ctx, data, opts)
+	for key, status := range result {
+		if status.Status == "error" {
+			log.Printf("Failed to set key %s in %s: %s\n", key, backend.Name(), status.Error)
+		}
+	}
+	return result
+}
+
+// setHandler writes all keys of the request and fails as a whole if any key
+// could not be written.
+func setHandler(w http.ResponseWriter, r *http.Request) {
+	data, opts, ok := decodeSetRequest(w, r)
+	if !ok {
+		return
+	}
+	// The keys of a request are written independently, so a condition that
+	// fails for one of several keys would leave the others written behind a
+	// 409 that suggests nothing was.
+	if opts.mode != setAlways && len(data) > 1 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Mode %s writes one key per /set request; use /mset for per-key results", opts.mode))
+		return
+	}
+
+	result := setKeys(r.Context(), data, opts)
+	for key, status := range result {
+		if status.Status == "not_set" {
+			writeError(w, http.StatusConflict, codeConditionFailed, fmt.Sprintf("Key %s was not set, mode %s does not hold", key, opts.mode))
+			return
+		}
+		if status.Status == "error" {
+			writeBackendError(w, r, errors.New(status.Error), fmt.Sprintf("Failed to set value in %s for key %s: %s", backend.Name(), key, status.Error))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resultsResponse[keyStatus]{result})
+}
+
+// msetHandler is the batch form of /set: it reports the status of every key
+// instead of failing the whole request.
+func msetHandler(w http.ResponseWriter, r *http.Request) {
+	data, opts, ok := decodeSetRequest(w, r)
+	if !ok {
+		return
+	}
+
+	result := setKeys(r.Context(), data, opts)
+	writeJSON(w, batchStatus(result), resultsResponse[keyStatus]{result})
+}
+
+// getHandler reads keys and reports for each whether it was found, its value
+// and its remaining TTL. A missing key is not an error.
+func getHandler(w http.ResponseWriter, r *http.Request) {
+	keys, ok := decodeKeys(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	entries, err := backend.Get(r.Context(), keys)
+	if err != nil {
+		log.Printf("Failed to get keys from %s: %v\n", backend.Name(), err)
+		writeBackendError(w, r, err, fmt.Sprintf("Failed to get value from %s", backend.Name()))
+		return
+	}
+
+	result := make(map[string]keyResult, len(entries))
+	for key, e := range entries {
+		if !e.Found {
+			result[key] = keyResult{}
+			continue
+		}
+		value, ttl := e.Value, ttlSeconds(e.TTL)
+		result[key] = keyResult{Found: true, Value: &value, TTL: &ttl}
+	}
+	writeJSON(w, http.StatusOK, resultsResponse[keyResult]{result})
+}
+
+// deleteHandler removes the given keys and reports how many existed.
+func deleteHandler(w http.ResponseWriter, r *http.Request) {
+	keys, ok := decodeKeys(w, r, http.MethodPost, http.MethodDelete)
+	if !ok {
+		return
+	}
+
+	deleted, err := backend.Delete(r.Context(), keys)
+	if err != nil {
+		log.Printf("Failed to delete keys %v from %s: %v\n", keys, backend.Name(), err)
+		writeBackendError(w, r, err, fmt.Sprintf("Failed to delete value from %s", backend.Name()))
+		return
+	}
+	debugf("Deleted %d of keys %v\n", deleted, keys)
+
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
+}
+
+// existsHandler reports for every key whether it is present.
+func existsHandler(w http.ResponseWriter, r *http.Request) {
+	keys, ok := decodeKeys(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	result, err := backend.Exists(r.Context(), keys)
+	if err != nil {
+		log.Printf("Failed to check keys %v in %s: %v\n", keys, backend.Name(), err)
+		writeBackendError(w, r, err, fmt.Sprintf("Failed to check keys in %s", backend.Name()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resultsResponse[bool]{result})
+}
+
+// ttlHandler returns the remaining time to live of one key. A key without
+// an expiry reports a ttl of -1.
+func ttlHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Key parameter is required")
+		return
+	}
+
+	ttl, err := backend.TTL(r.Context(), key)
+	if errors.Is(err, errKeyNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Key %s not found", key))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get ttl of key %s from %s: %v\n", key, backend.Name(), err)
+		writeBackendError(w, r, err, fmt.Sprintf("Failed to get ttl from %s", backend.Name()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "ttl": ttlSeconds(ttl)})
+}
+
+// expireHandler sets the time to live of an existing key. A ttl of 0 removes
+// the expiry so the key is kept until deleted.
+func expireHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
+	if key == "" || err != nil || ttl < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Key and a non-negative ttl are required")
+		return
+	}
+
+	err = backend.Expire(r.Context(), key, ttl)
+	if errors.Is(err, errKeyNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Key %s not found", key))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set ttl of key %s in %s: %v\n", key, backend.Name(), err)
+		writeBackendError(w, r, err, fmt.Sprintf("Failed to set ttl in %s", backend.Name()))
+		return
+	}
+	debugf("Set ttl of key %s to %v\n", key, ttl)
+
+	if ttl == 0 {
+		ttl = noExpiry
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "ttl": ttlSeconds(ttl)})
+}
+
+// shardsHandler reports how keys are spread over the shards (GET), adds a
+// node to the ring (POST ?addr=) or removes one (DELETE ?addr=). Changing
+// the ring does not migrate keys: the reported fraction of keys moves to
+// another node and misses there until it is written again.
+func shardsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, shards.ringStats(r.Context()))
+		return
+	}
+
+	addr := r.URL.Query().Get("addr")
+	if addr == "" || strings.Contains(addr, ",") {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "One addr parameter is required")
+		return
+	}
+	action, moved, err := "added", 0.0, error(nil)
+	if r.Method == http.MethodPost {
+		moved, err = shards.addNode(r.Context(), addr)
+	} else {
+		action = "removed"
+		moved, err = shards.removeNode(addr)
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, codeConditionFailed, fmt.Sprintf("Failed to change shards: %v", err))
+		return
+	}
+	log.Printf("Shard %s %s, %.1f%% of keys remapped\n", addr, action, moved*100)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"addr": addr, "action": action, "remapped": moved})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set on SIGTERM. From then on /readyz fails so load balancers
+// stop routing new requests, while requests already routed are still served.
+var draining atomic.Bool
+
+// healthResponse is the body of /healthz and /readyz.
+type healthResponse struct {
+	Status  string        `json:"status"` // "ok", "unavailable" or "draining"
+	Backend backendHealth `json:"backend"`
+}
+
+type backendHealth struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	PingMillis float64 `json:"ping_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func checkBackend(ctx context.Context) backendHealth {
+	h := backendHealth{Name: backend.Name()}
+	start := time.Now()
+	if err := backend.Ping(ctx); err != nil {
+		h.Error = err.Error()
+	} else {
+		h.OK = true
+	}
+	h.PingMillis = float64(time.Since(start).Microseconds()) / 1000
+	return h
+}
+
+// healthzHandler is the liveness probe. It reports whether the backend
+// answers but returns 200 either way: restarting the gateway does not bring
+// a lost backend back.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	h := healthResponse{Status: "ok", Backend: checkBackend(r.Context())}
+	if !h.Backend.OK {
+		h.Status = "unavailable"
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// readyzHandler is the readiness probe. It returns 503 while the backend
+// does not answer or the gateway is shutting down.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	h := healthResponse{Status: "ok", Backend: checkBackend(r.Context())}
+	status := http.StatusOK
+	switch {
+	case draining.Load():
+		h.Status = "draining"
+		status = http.StatusServiceUnavailable
+	case !h.Backend.OK:
+		h.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+// withRequestTimeout bounds every request, and the backend calls made with
+// its context, by -request-timeout.
+func withRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Eviction policies of the L1 cache.
+const (
+	l1Off = "off"
+	l1LRU = "lru"
+	l1LFU = "lfu"
+)
+
+// l1Cache is the in-process tier in front of the backend. It is bounded by
+// key count and by value bytes and evicts the least recently used (lru) or
+// least frequently used (lfu) entry when either limit is reached. Entries
+// also expire after their TTL.
+type l1Cache struct {
+	mu       sync.Mutex
+	policy   string
+	maxKeys  int
+	maxBytes int64
+	ttl      time.Duration // upper bound on how long an entry is served
+	bytes    int64
+	entries  map[string]*l1Entry
+	lru      *list.List // front is the most recently used
+	lfu      lfuHeap
+	tick     uint64
+	// epoch counts invalidations. A fill that started before an
+	// invalidation is dropped, so a read racing with a write cannot put the
+	// old value back into L1.
+	epoch uint64
+
+	hits, misses, evictions, invalidations uint64
+}
+
+type l1Entry struct {
+	key          string
+	value        string
+	expiresAt    time.Time // when L1 stops serving the entry
+	keyExpiresAt time.Time // when the key expires in the backend, zero if never
+	freq         uint64
+	lastUsed     uint64        // tie-break between equally frequent entries
+	elem         *list.Element // position in lru
+	index        int           // position in lfu
+}
+
+// keyTTL is the remaining backend TTL of the entry.
+func (e *l1Entry) keyTTL(now time.Time) time.Duration {
+	if e.keyExpiresAt.IsZero() {
+		return noExpiry
+	}
+	return e.keyExpiresAt.Sub(now)
+}
+
+func newL1Cache(policy string, maxKeys int, maxBytes int64, ttl time.Duration) *l1Cache {
+	return &l1Cache{
+		policy:   policy,
+		maxKeys:  maxKeys,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[string]*l1Entry),
+		lru:      list.New(),
+	}
+}
+
+func (c *l1Cache) get(key string, now time.Time) (*l1Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && !now.Before(e.expiresAt) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.touch(e)
+	copied := *e
+	return &copied, true
+}
+
+// currentEpoch is read before going to the backend and passed to fill.
+func (c *l1Cache) currentEpoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// fill stores a value read from the backend, where it has keyTTL left,
+// unless an invalidation happened since epoch was read. The entry is served
+// until the L1 TTL or the backend TTL runs out, whichever comes first.
+func (c *l1Cache) fill(key, value string, keyTTL time.Duration, now time.Time, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	if size := int64(len(key) + len(value)); c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	if old, ok := c.entries[key]; ok {
+		c.remove(old)
+	}
+	e := &l1Entry{key: key, value: value, expiresAt: now.Add(c.ttl)}
+	if keyTTL != noExpiry {
+		e.keyExpiresAt = now.Add(keyTTL)
+		if keyTTL < c.ttl {
+			e.expiresAt = e.keyExpiresAt
+		}
+	}
+	c.entries[key] = e
+	c.bytes += int64(len(key) + len(value))
+	switch c.policy {
+	case l1LFU:
+		heap.Push(&c.lfu, e)
+	default:
+		e.elem = c.lru.PushFront(e)
+	}
+	c.touch(e)
+	for len(c.entries) > c.maxKeys || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.evict()
+		c.evictions++
+	}
+}
+
+// invalidate drops keys and starts a new epoch.
+func (c *l1Cache) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.remove(e)
+			c.invalidations++
+		}
+	}
+}
+
+// touch records an access. The caller must hold c.mu.
+func (c *l1Cache) touch(e *l1Entry) {
+	c.tick++
+	e.freq++
+	e.lastUsed = c.tick
+	switch c.policy {
+	case l1LFU:
+		heap.Fix(&c.lfu, e.index)
+	default:
+		c.lru.MoveToFront(e.elem)
+	}
+}
+
+// evict removes the entry the policy ranks lowest. The caller must hold c.mu.
+func (c *l1Cache) evict() {
+	switch c.policy {
+	case l1LFU:
+		c.remove(c.lfu[0])
+	default:
+		c.remove(c.lru.Back().Value.(*l1Entry))
+	}
+}
+
+// remove deletes e. The caller must hold c.mu.
+func (c *l1Cache) remove(e *l1Entry) {
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.key) + len(e.value))
+	switch c.policy {
+	case l1LFU:
+		heap.Remove(&c.lfu, e.index)
+	default:
+		c.lru.Remove(e.elem)
+	}
+}
+
+// l1Stats is the L1 part of /stats.
+type l1Stats struct {
+	Policy        string `json:"policy"`
+	Keys          int    `json:"keys"`
+	Bytes         int64  `json:"bytes"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+func (c *l1Cache) stats() l1Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return l1Stats{
+		Policy:        c.policy,
+		Keys:          len(c.entries),
+		Bytes:         c.bytes,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+	}
+}
+
+// lfuHeap orders entries by access count, then by last access, so the least
+// frequently used entry is at the root.
+type lfuHeap []*l1Entry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].lastUsed < h[j].lastUsed
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*l1Entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
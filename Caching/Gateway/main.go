@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	cfg     *Config
+	backend Backend
+	shards  *shardedBackend // nil unless -shards is set
+)
+
+func main() {
+	var err error
+	cfg, err = parseConfig(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+
+	// ctx lives until SIGTERM or Ctrl-C and stops the background
+	// subscriptions.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	backend, err = newBackend(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create backend: %v\n", err)
+	}
+
+	// Check backend connectivity
+	if err := backend.Ping(ctx); err != nil {
+		addr := cfg.Addr
+		if cfg.Shards != "" {
+			addr = cfg.Shards
+		}
+		log.Fatalf("Failed to connect to %s at %s: %v\n", backend.Name(), addr, err)
+	}
+	log.Printf("Connected to %s successfully\n", backend.Name())
+
+	if rb, ok := backend.(*redisBackend); ok {
+		if err := rb.watchFailovers(ctx); err != nil {
+			log.Fatalf("Failed to subscribe to failovers on sentinel %s: %v\n", cfg.Addr, err)
+		}
+	}
+	if nr, ok := backend.(nodeReporter); ok {
+		http.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, nr.nodes(r.Context()))
+		})
+	}
+	if sb, ok := backend.(*shardedBackend); ok {
+		shards = sb
+		http.HandleFunc("/shards", shardsHandler)
+		log.Printf("Sharding keys over %d nodes with %d virtual nodes each\n", len(sb.ring.nodes), cfg.VNodes)
+	}
+
+	if cfg.L1Policy != l1Off {
+		near := newNearCache(backend, cfg)
+		if err := near.listen(ctx); err != nil {
+			log.Fatalf("Failed to subscribe to L1 invalidations on %s: %v\n", cfg.L1Channel, err)
+		}
+		backend = near
+		http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, near.stats())
+		})
+		log.Printf("Serving reads from a %s L1 cache of up to %d keys\n", cfg.L1Policy, cfg.L1MaxKeys)
+	}
+
+	http.HandleFunc("/set", setHandler)
+	http.HandleFunc("/get", getHandler)
+	http.HandleFunc("/mset", msetHandler)
+	http.HandleFunc("/mget", getHandler)
+	http.HandleFunc("/delete", deleteHandler)
+	http.HandleFunc("/exists", existsHandler)
+	http.HandleFunc("/ttl", ttlHandler)
+	http.HandleFunc("/expire", expireHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Unknown endpoint %s", r.URL.Path))
+	})
+
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      withRequestTimeout(http.DefaultServeMux),
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server is running on %s...\n", cfg.Listen)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+	shutdown(server)
+}
+
+// shutdown drains the gateway: /readyz fails for -drain-delay so load
+// balancers take it out of rotation, then the server stops accepting
+// connections and waits up to -shutdown-timeout for in-flight requests
+// before the backend connections are closed.
+func shutdown(server *http.Server) {
+	draining.Store(true)
+	log.Printf("Shutting down, draining for %v\n", cfg.DrainDelay)
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Failed to finish in-flight requests within %v: %v\n", cfg.ShutdownTimeout, err)
+	}
+	if err := backend.Close(); err != nil {
+		log.Printf("Failed to close %s: %v\n", backend.Name(), err)
+	}
+	log.Println("Server stopped")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps the cache in the gateway process. It needs no server,
+// which makes it handy for trying out the API and as a baseline that shows
+// how much of a request's latency the network hop to Redis costs.
+type memoryBackend struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time // zero for keys without an expiry
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{entries: make(map[string]memoryEntry)}
+}
+
+// lookup returns the entry for key, removing it first if it has expired.
+// The caller must hold b.mu.
+func (b *memoryBackend) lookup(key string, now time.Time) (memoryEntry, bool) {
+	entry, ok := b.entries[key]
+	if ok && !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+		delete(b.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+func (b *memoryBackend) Name() string {
+	return "memory"
+}
+
+func (b *memoryBackend) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *memoryBackend) Set(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	result := make(map[string]keyStatus, len(data))
+	for key, value := range data {
+		_, exists := b.lookup(key, now)
+		if (opts.mode == setIfNotExists && exists) || (opts.mode == setIfExists && !exists) {
+			result[key] = keyStatus{Status: "not_set"}
+			continue
+		}
+		entry := memoryEntry{value: value}
+		if opts.ttl > 0 {
+			entry.expiresAt = now.Add(opts.ttl)
+		}
+		b.entries[key] = entry
+		result[key] = keyStatus{Status: "ok"}
+	}
+	return result
+}
+
+func (b *memoryBackend) Get(ctx context.Context, keys []string) (map[string]entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	result := make(map[string]entry, len(keys))
+	for _, key := range keys {
+		e, ok := b.lookup(key, now)
+		if !ok {
+			result[key] = entry{}
+			continue
+		}
+		ttl := noExpiry
+		if !e.expiresAt.IsZero() {
+			ttl = e.expiresAt.Sub(now)
+		}
+		result[key] = entry{Found: true, Value: e.value, TTL: ttl}
+	}
+	return result, nil
+}
+
+func (b *memoryBackend) Delete(ctx context.Context, keys []string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := b.lookup(key, now); ok {
+			delete(b.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (b *memoryBackend) Exists(ctx context.Context, keys []string) (map[string]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		_, result[key] = b.lookup(key, now)
+	}
+	return result, nil
+}
+
+func (b *memoryBackend) TTL(ctx context.Context, key string) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	entry, ok := b.lookup(key, now)
+	if !ok {
+		return 0, errKeyNotFound
+	}
+	if entry.expiresAt.IsZero() {
+		return noExpiry, nil
+	}
+	return entry.expiresAt.Sub(now), nil
+}
+
+func (b *memoryBackend) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	entry, ok := b.lookup(key, now)
+	if !ok {
+		return errKeyNotFound
+	}
+	entry.expiresAt = time.Time{}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	b.entries[key] = entry
+	return nil
+}
+
+func (b *memoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// invalidationBus carries invalidated keys between gateway instances that
+// share a backend, so a write through one gateway evicts the key from the L1
+// cache of all of them. Backends without a bus (memory) only invalidate the
+// local L1, which is all a single process needs.
+type invalidationBus interface {
+	PublishInvalidation(ctx context.Context, msg invalidation) error
+	// SubscribeInvalidations subscribes to the bus and then calls handle
+	// for every message in the background until ctx is done.
+	SubscribeInvalidations(ctx context.Context, handle func(invalidation)) error
+}
+
+// invalidation is the message published on the bus. From identifies the
+// sending gateway, which has already invalidated its own L1.
+type invalidation struct {
+	From string   `json:"from"`
+	Keys []string `json:"keys"`
+}
+
+// nearCache is a Backend that serves reads from an in-process L1 cache and
+// forwards misses and all writes to the next backend.
+type nearCache struct {
+	Backend
+	l1  *l1Cache
+	bus invalidationBus
+	id  string
+
+	backendHits, backendMisses atomic.Uint64
+}
+
+func newNearCache(next Backend, cfg *Config) *nearCache {
+	id := make([]byte, 8)
+	rand.Read(id)
+	c := &nearCache{
+		Backend: next,
+		l1:      newL1Cache(cfg.L1Policy, cfg.L1MaxKeys, cfg.L1MaxBytes, cfg.L1TTL),
+		id:      hex.EncodeToString(id),
+	}
+	if bus, ok := next.(invalidationBus); ok {
+		c.bus = bus
+	}
+	return c
+}
+
+// listen applies invalidations published by other gateways. It returns once
+// the subscription is active, so no write from another gateway is missed
+// after the gateway starts serving. L1 entries expire after the L1 TTL,
+// which bounds staleness if a message is lost while the subscription
+// reconnects.
+func (c *nearCache) listen(ctx context.Context) error {
+	if c.bus == nil {
+		return nil
+	}
+	return c.bus.SubscribeInvalidations(ctx, func(msg invalidation) {
+		if msg.From != c.id {
+			c.l1.invalidate(msg.Keys)
+		}
+	})
+}
+
+func (c *nearCache) invalidate(ctx context.Context, keys []string) {
+	c.l1.invalidate(keys)
+	if c.bus == nil {
+		return
+	}
+	if err := c.bus.PublishInvalidation(ctx, invalidation{From: c.id, Keys: keys}); err != nil {
+		log.Printf("Failed to publish L1 invalidation for keys %v: %v\n", keys, err)
+	}
+}
+
+func (c *nearCache) Get(ctx context.Context, keys []string) (map[string]entry, error) {
+	now := time.Now()
+	epoch := c.l1.currentEpoch()
+	result := make(map[string]entry, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if e, ok := c.l1.get(key, now); ok {
+			result[key] = entry{Found: true, Value: e.value, TTL: e.keyTTL(now)}
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	fetched, err := c.Backend.Get(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for key, e := range fetched {
+		result[key] = e
+		if !e.Found {
+			c.backendMisses.Add(1)
+			continue
+		}
+		c.backendHits.Add(1)
+		c.l1.fill(key, e.Value, e.TTL, now, epoch)
+	}
+	return result, nil
+}
+
+func (c *nearCache) Set(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus {
+	result := c.Backend.Set(ctx, data, opts)
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	c.invalidate(ctx, keys)
+	return result
+}
+
+func (c *nearCache) Delete(ctx context.Context, keys []string) (int64, error) {
+	deleted, err := c.Backend.Delete(ctx, keys)
+	c.invalidate(ctx, keys)
+	return deleted, err
+}
+
+func (c *nearCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	err := c.Backend.Expire(ctx, key, ttl)
+	c.invalidate(ctx, []string{key})
+	return err
+}
+
+// tierStats is the /stats response.
+type tierStats struct {
+	L1      l1Stats      `json:"l1"`
+	Backend backendStats `json:"backend"`
+	HitRate float64      `json:"hit_rate"`
+}
+
+type backendStats struct {
+	Name   string `json:"name"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func (c *nearCache) stats() tierStats {
+	s := tierStats{
+		L1:      c.l1.stats(),
+		Backend: backendStats{Name: c.Name(), Hits: c.backendHits.Load(), Misses: c.backendMisses.Load()},
+	}
+	if lookups := s.L1.Hits + s.L1.Misses; lookups > 0 {
+		s.HitRate = float64(s.L1.Hits+s.Backend.Hits) / float64(lookups)
+	}
+	return s
+}
+
+func (b *redisBackend) PublishInvalidation(ctx context.Context, msg invalidation) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(ctx, b.channel, payload).Err()
+}
+
+func (b *redisBackend) SubscribeInvalidations(ctx context.Context, handle func(invalidation)) error {
+	pubsub := b.rdb.Subscribe(ctx, b.channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m := <-ch:
+				var msg invalidation
+				if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+					log.Printf("Ignoring malformed L1 invalidation %q: %v\n", m.Payload, err)
+					continue
+				}
+				handle(msg)
+			}
+		}
+	}()
+	return nil
+}
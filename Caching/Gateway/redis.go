@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Supported values of -topology.
+const (
+	topologySingle   = "single"
+	topologyCluster  = "cluster"
+	topologySentinel = "sentinel"
+)
+
+// redisBackend serves the gateway from Redis or from anything that speaks
+// its protocol, such as Dragonfly. It works against a single node, a Redis
+// Cluster or a master found through Sentinel; the go-redis clients follow
+// MOVED/ASK redirects and sentinel failovers on their own.
+type redisBackend struct {
+	name     string
+	addr     string // as configured, for log and error messages
+	topology string
+	rdb      redis.UniversalClient
+	channel  string // pub/sub channel for L1 invalidations
+
+	// Sentinel only: where to look up the master, and how many times it
+	// has changed while the gateway was running.
+	sentinel   *redis.SentinelClient
+	masterName string
+	failovers  atomic.Uint64
+
+	readReplicas bool
+}
+
+func newRedisBackend(name string, cfg *Config) *redisBackend {
+	opts := &redis.UniversalOptions{
+		Addrs:            strings.Split(cfg.Addr, ","),
+		MasterName:       cfg.MasterName,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		PoolSize:         cfg.PoolSize,
+		MaxRetries:       cfg.MaxRetries,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		ReadOnly:         cfg.ReadReplicas,
+		RouteByLatency:   cfg.ReadReplicas,
+	}
+	b := &redisBackend{
+		name:         name,
+		addr:         cfg.Addr,
+		topology:     cfg.Topology,
+		channel:      cfg.L1Channel,
+		masterName:   cfg.MasterName,
+		readReplicas: cfg.ReadReplicas,
+	}
+	switch cfg.Topology {
+	case topologyCluster:
+		b.rdb = redis.NewClusterClient(opts.Cluster())
+	case topologySentinel:
+		failover := opts.Failover()
+		failover.SlaveOnly = cfg.ReadReplicas
+		b.rdb = redis.NewFailoverClient(failover)
+		b.sentinel = redis.NewSentinelClient(&redis.Options{Addr: opts.Addrs[0], Password: cfg.SentinelPassword})
+	default:
+		b.rdb = redis.NewClient(opts.Simple())
+	}
+	return b
+}
+
+// watchFailovers logs every master switch Sentinel announces for the
+// gateway's master. The failover client reconnects by itself; this only
+// makes the switch visible in the logs and in /nodes.
+func (b *redisBackend) watchFailovers(ctx context.Context) error {
+	if b.sentinel == nil {
+		return nil
+	}
+	pubsub := b.sentinel.Subscribe(ctx, "+switch-master")
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			// The payload is "<master name> <old ip> <old port> <new ip> <new port>".
+			fields := strings.Fields(msg.Payload)
+			if len(fields) != 5 || fields[0] != b.masterName {
+				continue
+			}
+			b.failovers.Add(1)
+			log.Printf("%s master %s failed over from %s:%s to %s:%s\n", b.name, fields[0], fields[1], fields[2], fields[3], fields[4])
+		}
+	}()
+	return nil
+}
+
+func (b *redisBackend) Name() string {
+	return b.name
+}
+
+func (b *redisBackend) Ping(ctx context.Context) error {
+	return b.rdb.Ping(ctx).Err()
+}
+
+// Set writes all pairs in a single pipeline, so a batch costs one round
+// trip, and reports the result of every SET separately. The pipeline's own
+// error is the first failed command, which is already in the per-key results.
+func (b *redisBackend) Set(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus {
+	keys := make([]string, 0, len(data))
+	cmds := make([]redis.Cmder, 0, len(data))
+	b.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range data {
+			keys = append(keys, key)
+			switch opts.mode {
+			case setIfNotExists:
+				cmds = append(cmds, pipe.SetNX(ctx, key, value, opts.ttl))
+			case setIfExists:
+				cmds = append(cmds, pipe.SetXX(ctx, key, value, opts.ttl))
+			default:
+				cmds = append(cmds, pipe.Set(ctx, key, value, opts.ttl))
+			}
+		}
+		return nil
+	})
+
+	result := make(map[string]keyStatus, len(keys))
+	for i, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			result[keys[i]] = keyStatus{Status: "error", Error: err.Error()}
+			continue
+		}
+		if set, ok := cmd.(*redis.BoolCmd); ok && !set.Val() {
+			result[keys[i]] = keyStatus{Status: "not_set"}
+			continue
+		}
+		result[keys[i]] = keyStatus{Status: "ok"}
+	}
+	return result
+}
+
+// Get reads all keys with one MGET, pipelined with a PTTL per key so the
+// values and their TTLs come back in a single round trip. A cluster rejects
+// an MGET whose keys live in different slots, so there every key gets its own
+// GET; the cluster pipeline still sends them in one round trip per node.
+func (b *redisBackend) Get(ctx context.Context, keys []string) (map[string]entry, error) {
+	values := make([]interface{}, len(keys))
+	var mget *redis.SliceCmd
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := b.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if b.topology == topologyCluster {
+			for i, key := range keys {
+				gets[i] = pipe.Get(ctx, key)
+			}
+		} else {
+			mget = pipe.MGet(ctx, keys...)
+		}
+		for i, key := range keys {
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if mget != nil {
+		values = mget.Val()
+	} else {
+		for i, get := range gets {
+			switch err := get.Err(); err {
+			case nil:
+				values[i] = get.Val()
+			case redis.Nil:
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	result := make(map[string]entry, len(keys))
+	for i, key := range keys {
+		value, ok := values[i].(string)
+		if !ok {
+			result[key] = entry{}
+			continue
+		}
+		ttl := ttls[i].Val()
+		if ttl < 0 {
+			ttl = noExpiry
+		}
+		result[key] = entry{Found: true, Value: value, TTL: ttl}
+	}
+	return result, nil
+}
+
+// Delete removes all keys with one DEL, or with one DEL per key in a
+// cluster, where a multi-key DEL must not span slots.
+func (b *redisBackend) Delete(ctx context.Context, keys []string) (int64, error) {
+	if b.topology != topologyCluster {
+		return b.rdb.Del(ctx, keys...).Result()
+	}
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := b.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted, nil
+}
+
+// Exists pipelines one EXISTS per key, since a multi-key EXISTS only returns
+// a count.
+func (b *redisBackend) Exists(ctx context.Context, keys []string) (map[string]bool, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := b.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		result[key] = cmds[i].Val() > 0
+	}
+	return result, nil
+}
+
+func (b *redisBackend) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := b.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL answers -2 for a missing key and -1 for one without an expiry.
+	switch ttl {
+	case -2:
+		return 0, errKeyNotFound
+	case -1:
+		return noExpiry, nil
+	}
+	return ttl, nil
+}
+
+func (b *redisBackend) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	var updated bool
+	var err error
+	if ttl == 0 {
+		updated, err = b.rdb.Persist(ctx, key).Result()
+		if err == nil && !updated {
+			// PERSIST is false both for a missing key and for one that
+			// never expired, only the former is an error here.
+			var exists int64
+			exists, err = b.rdb.Exists(ctx, key).Result()
+			updated = exists > 0
+		}
+	} else {
+		updated, err = b.rdb.PExpire(ctx, key, ttl).Result()
+	}
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errKeyNotFound
+	}
+	return nil
+}
+
+func (b *redisBackend) Close() error {
+	if b.sentinel != nil {
+		b.sentinel.Close()
+	}
+	return b.rdb.Close()
+}
+
+// nodeStats is one entry of /nodes.
+type nodeStats struct {
+	Addr       string  `json:"addr"`
+	Role       string  `json:"role"`
+	PingMillis float64 `json:"ping_ms"`
+	Error      string  `json:"error,omitempty"`
+	// Connection pool of the gateway to this node.
+	PoolHits     uint32 `json:"pool_hits"`
+	PoolMisses   uint32 `json:"pool_misses"`
+	PoolTimeouts uint32 `json:"pool_timeouts"`
+	TotalConns   uint32 `json:"total_conns"`
+	IdleConns    uint32 `json:"idle_conns"`
+}
+
+// topologyStats is the /nodes response.
+type topologyStats struct {
+	Backend   string      `json:"backend"`
+	Topology  string      `json:"topology"`
+	Failovers uint64      `json:"failovers,omitempty"`
+	Nodes     []nodeStats `json:"nodes"`
+}
+
+// nodeReporter is implemented by backends that can serve /nodes.
+type nodeReporter interface {
+	nodes(ctx context.Context) topologyStats
+}
+
+func probeNode(ctx context.Context, client *redis.Client, role string) nodeStats {
+	stats := client.PoolStats()
+	node := nodeStats{
+		Addr:         client.Options().Addr,
+		Role:         role,
+		PoolHits:     stats.Hits,
+		PoolMisses:   stats.Misses,
+		PoolTimeouts: stats.Timeouts,
+		TotalConns:   stats.TotalConns,
+		IdleConns:    stats.IdleConns,
+	}
+	start := time.Now()
+	if err := client.Ping(ctx).Err(); err != nil {
+		node.Error = err.Error()
+	}
+	node.PingMillis = float64(time.Since(start).Microseconds()) / 1000
+	return node
+}
+
+// nodes pings every node the gateway talks to and reports its latency and
+// the gateway's connection pool to it.
+func (b *redisBackend) nodes(ctx context.Context) topologyStats {
+	s := topologyStats{Backend: b.name, Topology: b.topology, Failovers: b.failovers.Load()}
+	switch client := b.rdb.(type) {
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		collect := func(role string) func(ctx context.Context, node *redis.Client) error {
+			return func(ctx context.Context, node *redis.Client) error {
+				stats := probeNode(ctx, node, role)
+				mu.Lock()
+				defer mu.Unlock()
+				s.Nodes = append(s.Nodes, stats)
+				return nil
+			}
+		}
+		client.ForEachMaster(ctx, collect("master"))
+		client.ForEachSlave(ctx, collect("replica"))
+	case *redis.Client:
+		role := "master"
+		if b.topology == topologySentinel {
+			// The failover client has a single pool to whichever node
+			// Sentinel currently names, so report what it names.
+			role = fmt.Sprintf("master %s", b.masterName)
+			if b.readReplicas {
+				role = fmt.Sprintf("replica of %s", b.masterName)
+			}
+			if addr, err := b.sentinel.GetMasterAddrByName(ctx, b.masterName).Result(); err == nil && len(addr) == 2 {
+				role += fmt.Sprintf(", master at %s:%s", addr[0], addr[1])
+			}
+		}
+		s.Nodes = append(s.Nodes, probeNode(ctx, client, role))
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hashRing maps keys to nodes with consistent hashing. Every node is placed
+// on the ring at vnodes pseudo-random points, and a key belongs to the node
+// of the first point at or after the key's hash. Adding or removing a node
+// therefore only moves the keys between that node's points and their
+// predecessors, about 1/N of the keys, and the virtual nodes keep the share
+// of every node close to 1/N.
+//
+// A hashRing is never modified; add and remove return a new ring, so readers
+// can use one without locking.
+type hashRing struct {
+	vnodes int
+	nodes  []string
+	points []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+func newHashRing(vnodes int, nodes []string) *hashRing {
+	r := &hashRing{vnodes: vnodes, nodes: append([]string(nil), nodes...)}
+	sort.Strings(r.nodes)
+	for _, node := range r.nodes {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, ringPoint{xxhash.Sum64String(fmt.Sprintf("%s#%d", node, i)), node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+func (r *hashRing) has(node string) bool {
+	for _, n := range r.nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *hashRing) add(node string) *hashRing {
+	return newHashRing(r.vnodes, append(r.nodes, node))
+}
+
+func (r *hashRing) remove(node string) *hashRing {
+	nodes := make([]string, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		if n != node {
+			nodes = append(nodes, n)
+		}
+	}
+	return newHashRing(r.vnodes, nodes)
+}
+
+// locate returns the node that owns key.
+func (r *hashRing) locate(key string) string {
+	h := xxhash.Sum64String(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// ownership returns the fraction of the hash space, and so of a uniformly
+// spread key set, that every node owns.
+func (r *hashRing) ownership() map[string]float64 {
+	shares := make(map[string]float64, len(r.nodes))
+	for _, node := range r.nodes {
+		shares[node] = 0
+	}
+	for i, p := range r.points {
+		// Point i owns the arc from the previous point up to itself.
+		prev := r.points[(i+len(r.points)-1)%len(r.points)].hash
+		shares[p.node] += float64(p.hash-prev) / math.MaxUint64
+	}
+	if len(r.points) == 1 {
+		shares[r.points[0].node] = 1
+	}
+	return shares
+}
+
+// remapped returns the fraction of keys that change owner between r and
+// next.
+func (r *hashRing) remapped(next *hashRing) float64 {
+	before, after := r.ownership(), next.ownership()
+	moved := 0.0
+	for node, share := range before {
+		if share > after[node] {
+			moved += share - after[node]
+		}
+	}
+	return moved
+}
+
+// skew is the largest value divided by the mean, 1 for a perfectly even
+// spread.
+func skew[V int64 | float64](values map[string]V) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var total, largest V
+	for _, v := range values {
+		total += v
+		if v > largest {
+			largest = v
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(largest) / (float64(total) / float64(len(values)))
+}
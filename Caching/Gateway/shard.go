@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardedBackend spreads keys over several independent Redis or Dragonfly
+// nodes with a consistent hash ring. Multi-key requests are split by node
+// and sent to all nodes in parallel.
+//
+// Nodes can be added and removed while the gateway runs. Keys are not
+// migrated: the few keys that change owner are cache misses on their new
+// node, and their old copies expire or stay unreachable on the old one.
+type shardedBackend struct {
+	name string
+	cfg  *Config
+	// bus is the node used for L1 invalidations. It stays the same even if
+	// it is removed from the ring, so all gateways keep sharing a channel.
+	bus *redisBackend
+
+	mu       sync.RWMutex
+	ring     *hashRing
+	shards   map[string]*redisBackend
+	requests map[string]*atomic.Uint64
+	// inflight counts the requests using each shard, so that a removed
+	// node's client is only closed once the requests routed to it are done.
+	inflight map[*redisBackend]*sync.WaitGroup
+}
+
+func newShardedBackend(name string, cfg *Config) *shardedBackend {
+	b := &shardedBackend{
+		name:     name,
+		cfg:      cfg,
+		shards:   make(map[string]*redisBackend),
+		requests: make(map[string]*atomic.Uint64),
+		inflight: make(map[*redisBackend]*sync.WaitGroup),
+	}
+	nodes := strings.Split(cfg.Shards, ",")
+	for _, node := range nodes {
+		shard := b.connect(node)
+		b.shards[node] = shard
+		b.requests[node] = new(atomic.Uint64)
+		b.inflight[shard] = new(sync.WaitGroup)
+	}
+	b.ring = newHashRing(cfg.VNodes, nodes)
+	b.bus = b.shards[b.ring.nodes[0]]
+	return b
+}
+
+func (b *shardedBackend) connect(node string) *redisBackend {
+	shardCfg := *b.cfg
+	shardCfg.Addr = node
+	return newRedisBackend(b.name, &shardCfg)
+}
+
+// split groups keys by the node that owns them. The shards stay open until
+// release is called, even if their node is removed in the meantime.
+func (b *shardedBackend) split(keys []string) (groups map[*redisBackend][]string, release func()) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	groups = make(map[*redisBackend][]string)
+	for _, key := range keys {
+		node := b.ring.locate(key)
+		b.requests[node].Add(1)
+		groups[b.shards[node]] = append(groups[b.shards[node]], key)
+	}
+	shards := make([]*redisBackend, 0, len(groups))
+	for shard := range groups {
+		shards = append(shards, shard)
+	}
+	return groups, b.hold(shards)
+}
+
+func (b *shardedBackend) shard(key string) (shard *redisBackend, release func()) {
+	groups, release := b.split([]string{key})
+	for shard := range groups {
+		return shard, release
+	}
+	return nil, release
+}
+
+// hold keeps shards open until the returned function is called. b.mu must
+// be held.
+func (b *shardedBackend) hold(shards []*redisBackend) (release func()) {
+	held := make([]*sync.WaitGroup, len(shards))
+	for i, shard := range shards {
+		held[i] = b.inflight[shard]
+		held[i].Add(1)
+	}
+	return func() {
+		for _, wg := range held {
+			wg.Done()
+		}
+	}
+}
+
+// fanOut runs call for every group in parallel and returns the first error.
+func fanOut(groups map[*redisBackend][]string, call func(shard *redisBackend, keys []string) error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groups))
+	for shard, keys := range groups {
+		wg.Add(1)
+		go func(shard *redisBackend, keys []string) {
+			defer wg.Done()
+			if err := call(shard, keys); err != nil {
+				errs <- fmt.Errorf("%s: %w", shard.addr, err)
+			}
+		}(shard, keys)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (b *shardedBackend) Name() string {
+	return b.name
+}
+
+func (b *shardedBackend) Ping(ctx context.Context) error {
+	b.mu.RLock()
+	groups := make(map[*redisBackend][]string, len(b.shards))
+	shards := make([]*redisBackend, 0, len(b.shards))
+	for _, shard := range b.shards {
+		groups[shard] = nil
+		shards = append(shards, shard)
+	}
+	release := b.hold(shards)
+	b.mu.RUnlock()
+	defer release()
+	return fanOut(groups, func(shard *redisBackend, _ []string) error {
+		return shard.Ping(ctx)
+	})
+}
+
+func (b *shardedBackend) Set(ctx context.Context, data map[string]string, opts setOptions) map[string]keyStatus {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	var mu sync.Mutex
+	result := make(map[string]keyStatus, len(data))
+	groups, release := b.split(keys)
+	defer release()
+	fanOut(groups, func(shard *redisBackend, keys []string) error {
+		part := make(map[string]string, len(keys))
+		for _, key := range keys {
+			part[key] = data[key]
+		}
+		statuses := shard.Set(ctx, part, opts)
+		mu.Lock()
+		defer mu.Unlock()
+		for key, status := range statuses {
+			result[key] = status
+		}
+		return nil
+	})
+	return result
+}
+
+func (b *shardedBackend) Get(ctx context.Context, keys []string) (map[string]entry, error) {
+	var mu sync.Mutex
+	result := make(map[string]entry, len(keys))
+	groups, release := b.split(keys)
+	defer release()
+	err := fanOut(groups, func(shard *redisBackend, keys []string) error {
+		entries, err := shard.Get(ctx, keys)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for key, e := range entries {
+			result[key] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *shardedBackend) Delete(ctx context.Context, keys []string) (int64, error) {
+	var deleted atomic.Int64
+	groups, release := b.split(keys)
+	defer release()
+	err := fanOut(groups, func(shard *redisBackend, keys []string) error {
+		n, err := shard.Delete(ctx, keys)
+		deleted.Add(n)
+		return err
+	})
+	return deleted.Load(), err
+}
+
+func (b *shardedBackend) Exists(ctx context.Context, keys []string) (map[string]bool, error) {
+	var mu sync.Mutex
+	result := make(map[string]bool, len(keys))
+	groups, release := b.split(keys)
+	defer release()
+	err := fanOut(groups, func(shard *redisBackend, keys []string) error {
+		exists, err := shard.Exists(ctx, keys)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for key, ok := range exists {
+			result[key] = ok
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *shardedBackend) TTL(ctx context.Context, key string) (time.Duration, error) {
+	shard, release := b.shard(key)
+	defer release()
+	return shard.TTL(ctx, key)
+}
+
+func (b *shardedBackend) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	shard, release := b.shard(key)
+	defer release()
+	return shard.Expire(ctx, key, ttl)
+}
+
+func (b *shardedBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for node, shard := range b.shards {
+		if shard != b.bus {
+			shard.Close()
+		}
+		delete(b.shards, node)
+	}
+	return b.bus.Close()
+}
+
+func (b *shardedBackend) PublishInvalidation(ctx context.Context, msg invalidation) error {
+	return b.bus.PublishInvalidation(ctx, msg)
+}
+
+func (b *shardedBackend) SubscribeInvalidations(ctx context.Context, handle func(invalidation)) error {
+	return b.bus.SubscribeInvalidations(ctx, handle)
+}
+
+// addNode connects to node and puts it on the ring. It returns the fraction
+// of keys that moved to the new node.
+func (b *shardedBackend) addNode(ctx context.Context, node string) (float64, error) {
+	shard := b.connect(node)
+	if err := shard.Ping(ctx); err != nil {
+		shard.Close()
+		return 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ring.has(node) {
+		shard.Close()
+		return 0, fmt.Errorf("node %s is already on the ring", node)
+	}
+	next := b.ring.add(node)
+	moved := b.ring.remapped(next)
+	b.ring = next
+	b.shards[node] = shard
+	b.requests[node] = new(atomic.Uint64)
+	b.inflight[shard] = new(sync.WaitGroup)
+	return moved, nil
+}
+
+// removeNode takes node off the ring. It returns the fraction of keys that
+// moved to the remaining nodes. The node's client is closed in the
+// background once the requests already routed to it have finished.
+func (b *shardedBackend) removeNode(node string) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ring.has(node) {
+		return 0, fmt.Errorf("node %s is not on the ring", node)
+	}
+	if len(b.ring.nodes) == 1 {
+		return 0, errors.New("cannot remove the last node")
+	}
+	next := b.ring.remove(node)
+	moved := b.ring.remapped(next)
+	b.ring = next
+	shard := b.shards[node]
+	if inflight := b.inflight[shard]; shard != b.bus {
+		go func() {
+			inflight.Wait()
+			shard.Close()
+		}()
+	}
+	delete(b.shards, node)
+	delete(b.requests, node)
+	delete(b.inflight, shard)
+	return moved, nil
+}
+
+// ringStats is the /shards response.
+type ringStats struct {
+	VNodes int             `json:"vnodes"`
+	Nodes  []ringNodeStats `json:"nodes"`
+	Skew   ringSkew        `json:"skew"`
+}
+
+type ringNodeStats struct {
+	Addr      string  `json:"addr"`
+	Ownership float64 `json:"ownership"`
+	Keys      int64   `json:"keys"`
+	Requests  uint64  `json:"requests"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ringSkew compares the busiest node to the average: 1 is a perfectly even
+// spread, 2 means one node has twice its fair share.
+type ringSkew struct {
+	Ownership float64 `json:"ownership"`
+	Keys      float64 `json:"keys"`
+	Requests  float64 `json:"requests"`
+}
+
+// ringStats reports how keys are spread: the share of the hash space every
+// node owns, the keys it actually stores (DBSIZE, so the nodes should not be
+// shared with other applications) and the keys routed to it.
+func (b *shardedBackend) ringStats(ctx context.Context) ringStats {
+	b.mu.RLock()
+	ring := b.ring
+	shards := make(map[string]*redisBackend, len(b.shards))
+	held := make([]*redisBackend, 0, len(b.shards))
+	for node, shard := range b.shards {
+		shards[node] = shard
+		held = append(held, shard)
+	}
+	requests := make(map[string]int64, len(b.requests))
+	for node, n := range b.requests {
+		requests[node] = int64(n.Load())
+	}
+	release := b.hold(held)
+	b.mu.RUnlock()
+	defer release()
+
+	s := ringStats{VNodes: ring.vnodes}
+	ownership := ring.ownership()
+	keys := make(map[string]int64, len(shards))
+	for _, node := range ring.nodes {
+		stats := ringNodeStats{Addr: node, Ownership: ownership[node], Requests: uint64(requests[node])}
+		size, err := shards[node].rdb.DBSize(ctx).Result()
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		stats.Keys = size
+		keys[node] = size
+		s.Nodes = append(s.Nodes, stats)
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Addr < s.Nodes[j].Addr })
+	s.Skew = ringSkew{Ownership: skew(ownership), Keys: skew(keys), Requests: skew(requests)}
+	return s
+}
+
+// nodes reports every shard like /nodes does for a single backend.
+func (b *shardedBackend) nodes(ctx context.Context) topologyStats {
+	b.mu.RLock()
+	shards := make([]*redisBackend, 0, len(b.shards))
+	for _, shard := range b.shards {
+		shards = append(shards, shard)
+	}
+	release := b.hold(shards)
+	b.mu.RUnlock()
+	defer release()
+
+	s := topologyStats{Backend: b.name, Topology: "sharded"}
+	for _, shard := range shards {
+		s.Nodes = append(s.Nodes, shard.nodes(ctx).Nodes...)
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Addr < s.Nodes[j].Addr })
+	return s
+}
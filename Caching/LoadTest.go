@@ -1,145 +1,542 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"net/http"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// Error classes reported in the summary. Transport errors are split by cause
+// so a saturated server (timeouts) can be told apart from one that is down
+// (connection refused) or one that answers with errors (HTTP status).
+const (
+	errTimeout     = "timeout"
+	errConnRefused = "connection_refused"
+	errConnReset   = "connection_reset"
+	errHTTPStatus  = "http_status"
+	errServer      = "server_error"
+	errOther       = "other"
+)
+
 type Metrics struct {
 	TotalRequests int
 	Successful    int
 	Failed        int
-	TotalLatency  time.Duration
-	MinLatency    time.Duration
-	MaxLatency    time.Duration
+	Lookups       int
+	Misses        int
+	ConnsNew      int
+	ConnsReused   int
+	ValueBytes    int64
+	StatusCodes   map[int]int
+	Errors        map[string]int
+	latency       *Histogram
+	service       *Histogram
+	started       time.Time
+	series        []*intervalStats
 	mu            sync.Mutex
 }
 
-func (m *Metrics) RecordSuccess(latency time.Duration) {
+// intervalStats holds the requests completed during one second of a phase.
+type intervalStats struct {
+	requests int
+	failed   int
+	latency  *Histogram
+}
+
+// NewMetrics starts a recorder. The per-second time series is measured from
+// the moment NewMetrics is called, so create it right before the phase starts.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		StatusCodes: make(map[int]int),
+		Errors:      make(map[string]int),
+		latency:     NewHistogram(),
+		service:     NewHistogram(),
+		started:     time.Now(),
+	}
+}
+
+// interval returns the stats for the current second, growing the series as
+// needed. The caller must hold m.mu.
+func (m *Metrics) interval() *intervalStats {
+	second := int(time.Since(m.started) / time.Second)
+	for len(m.series) <= second {
+		m.series = append(m.series, &intervalStats{latency: NewHistogram()})
+	}
+	return m.series[second]
+}
+
+// RecordSuccess records a successful request. latency is measured from its
+// intended and service from its actual send time, which only differ in
+// open-loop mode, where latency includes the time it waited for its turn. lookups is the number of
+// keys it read and misses how many of them the server did not have;
+// valueBytes is the size of the value it wrote. status is the HTTP status
+// code, or 0 for protocols without one.
+func (m *Metrics) RecordSuccess(status int, latency, service time.Duration, lookups, misses, valueBytes int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.TotalRequests++
 	m.Successful++
-	m.TotalLatency += latency
-	if m.MinLatency == 0 || latency < m.MinLatency {
-		m.MinLatency = latency
-	}
-	if latency > m.MaxLatency {
-		m.MaxLatency = latency
+	m.ValueBytes += int64(valueBytes)
+	if status != 0 {
+		m.StatusCodes[status]++
 	}
+	m.Lookups += lookups
+	m.Misses += misses
+	m.latency.Record(latency)
+	m.service.Record(service)
+	interval := m.interval()
+	interval.requests++
+	interval.latency.Record(latency)
 }
 
-func (m *Metrics) RecordFailure() {
+// RecordFailure records a failed request. status is the HTTP status code for
+// non-2xx responses and 0 for transport errors.
+func (m *Metrics) RecordFailure(class string, status int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.TotalRequests++
 	m.Failed++
+	m.Errors[class]++
+	interval := m.interval()
+	interval.requests++
+	interval.failed++
+	if status != 0 {
+		m.StatusCodes[status]++
+	}
+}
+
+// RecordConn records whether a request was sent on a pooled connection.
+func (m *Metrics) RecordConn(reused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reused {
+		m.ConnsReused++
+	} else {
+		m.ConnsNew++
+	}
+}
+
+// HitRate is the share of looked up keys that were found.
+func (m *Metrics) HitRate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Lookups == 0 {
+		return 0
+	}
+	return float64(m.Lookups-m.Misses) / float64(m.Lookups)
+}
+
+// ErrorRate is the share of all requests that failed.
+func (m *Metrics) ErrorRate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.TotalRequests == 0 {
+		return 0
+	}
+	return float64(m.Failed) / float64(m.TotalRequests)
 }
 
 func (m *Metrics) AverageLatency() time.Duration {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if m.Successful == 0 {
+	return m.latency.Mean()
+}
+
+func (m *Metrics) MinLatency() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latency.Min()
+}
+
+func (m *Metrics) MaxLatency() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latency.Max()
+}
+
+// Percentile returns the latency at percentile p, e.g. 99.9 for p99.9.
+func (m *Metrics) Percentile(p float64) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latency.ValueAtQuantile(p / 100)
+}
+
+func (m *Metrics) PrintSummary(name string) {
+	fmt.Printf("%s Metrics: Total Requests: %d, Successful: %d, Failed: %d, Average Latency: %v, Min Latency: %v, Max Latency: %v\n",
+		name, m.TotalRequests, m.Successful, m.Failed, m.AverageLatency(), m.MinLatency(), m.MaxLatency())
+	if name == opGet {
+		fmt.Printf("%s Hit Rate: %.2f%% (%d misses)\n", name, m.HitRate()*100, m.Misses)
+	}
+	fmt.Printf("%s Error Rate: %.2f%%\n", name, m.ErrorRate()*100)
+	if m.ValueBytes > 0 {
+		fmt.Printf("%s Value Bytes Written: %d (%.2f MB/s)\n", name, m.ValueBytes, m.Summary(time.Now()).Bandwidth)
+	}
+	if len(m.StatusCodes) > 0 {
+		fmt.Printf("%s Status Codes: %s\n", name, formatCounts(m.StatusCodes))
+	}
+	fmt.Printf("%s Connections: %d new, %d reused\n", name, m.ConnsNew, m.ConnsReused)
+	if len(m.Errors) > 0 {
+		fmt.Printf("%s Errors: %s\n", name, formatCounts(m.Errors))
+	}
+	fmt.Printf("%s Latency Percentiles: p50: %v, p90: %v, p99: %v, p99.9: %v\n",
+		name, m.Percentile(50), m.Percentile(90), m.Percentile(99), m.Percentile(99.9))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Printf("%s Service Time Percentiles: p50: %v, p90: %v, p99: %v, p99.9: %v\n",
+		name, m.service.ValueAtQuantile(0.50), m.service.ValueAtQuantile(0.90), m.service.ValueAtQuantile(0.99), m.service.ValueAtQuantile(0.999))
+	if lag := m.lagShare(); lag > 0.5 {
+		fmt.Printf("%s Warning: %.0f%% of the mean latency passed before requests were sent, so the latency mostly measures the load generator: timer and scheduling lag, the -concurrency limit or a busy client. Compare the service time.\n",
+			name, lag*100)
+	}
+	fmt.Printf("%s Latency Distribution:\n", name)
+	m.latency.PrintDistribution(os.Stdout)
+}
+
+// lagShare is the share of the mean latency that requests spent between
+// their intended and actual send time. The caller must hold m.mu.
+func (m *Metrics) lagShare() float64 {
+	latency := m.latency.Mean()
+	if latency <= 0 {
 		return 0
 	}
-	return m.TotalLatency / time.Duration(m.Successful)
+	return 1 - float64(m.service.Mean())/float64(latency)
 }
 
-func generateKeyValuePairs(count int) map[string]string {
-	keyValues := make(map[string]string, count)
-	for i := 0; i < count; i++ {
-		key := fmt.Sprintf("key-%d", i)
-		value := fmt.Sprintf("value-%d", i)
-		keyValues[key] = value
+// formatCounts renders a counter map as "k=v" pairs in key order.
+func formatCounts[K int | string](counts map[K]int) string {
+	keys := make([]K, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%v=%d", k, counts[k]))
+	}
+	if len(parts) == 0 {
+		return "none"
 	}
-	return keyValues
+	return strings.Join(parts, ", ")
+}
+
+const (
+	opSet = "SET"
+	opGet = "GET"
+)
+
+// operation is a single request issued by the load generator. A GET with a
+// batch reads key and the batch keys in one request (multi-key /get or MGET).
+type operation struct {
+	name  string
+	key   string
+	value string
+	batch []string
+}
+
+func (op operation) keys() []string {
+	return append([]string{op.key}, op.batch...)
 }
 
-func performSetOperations(keyValues map[string]string, rps int, duration time.Duration, metrics *Metrics) {
-	ticker := time.NewTicker(time.Second / time.Duration(rps))
+// generateKeys returns count keys in creation order.
+func generateKeys(count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
+
+// classifyError maps a transport error to one of the reported error classes.
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errConnReset
+	default:
+		return errOther
+	}
+}
+
+// performOperations runs the operations produced by next for one stage,
+// using the open- or closed-loop model from cfg. It stops early when next
+// runs out or, for warmup stages with a steady-state window, once every
+// recorder in metrics has reached steady state.
+func performOperations(cfg *Config, stage Stage, driver Driver, next func() (operation, bool), metrics map[string]*Metrics) {
+	var stop atomic.Bool
+	if stage.Warmup && stage.SteadyWindow > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go watchSteadyState(stage, metrics, &stop, done)
+	}
+	if cfg.Mode == modeClosed {
+		performClosedLoop(cfg, stage, driver, next, metrics, &stop)
+		return
+	}
+	performOpenLoop(cfg, stage, driver, next, metrics, &stop)
+}
+
+// watchSteadyState sets stop once all recorders in metrics are steady.
+func watchSteadyState(stage Stage, metrics map[string]*Metrics, stop *atomic.Bool, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			steady := true
+			for _, m := range metrics {
+				if !m.isSteady(stage.SteadyWindow, stage.SteadyTolerance) {
+					steady = false
+				}
+			}
+			if steady {
+				stop.Store(true)
+				return
+			}
+		}
+	}
+}
 
-	endTime := time.Now().Add(duration)
+// performOpenLoop issues requests on the stage's rate schedule, keeping at
+// most the stage's concurrency in flight. Latency is measured from each
+// request's intended send time, which corrects for coordinated omission:
+// when the server stalls and the schedule falls behind, the requests that
+// should have been sent meanwhile are charged for the time they waited.
+func performOpenLoop(cfg *Config, stage Stage, driver Driver, next func() (operation, bool), metrics map[string]*Metrics, stop *atomic.Bool) {
+	inFlight := make(chan struct{}, stage.concurrency(cfg))
 	var wg sync.WaitGroup
 
-	for key, value := range keyValues {
-		if time.Now().After(endTime) {
+	start := time.Now()
+	endTime := start.Add(time.Duration(stage.Duration))
+	for intended := start; intended.Before(endTime) && !stop.Load(); {
+		op, ok := next()
+		if !ok {
 			break
 		}
-		<-ticker.C
+		time.Sleep(time.Until(intended))
+		inFlight <- struct{}{}
 		wg.Add(1)
-		go func(k, v string) {
+		go func(op operation, intended time.Time) {
 			defer wg.Done()
-			start := time.Now()
-			data := map[string]string{k: v}
-			body, _ := json.Marshal(data)
-			resp, err := http.Post("http://localhost:8080/set", "application/json", bytes.NewBuffer(body))
-			latency := time.Since(start)
-			if err != nil {
-				fmt.Printf("Failed to set key %s: %v\n", k, err)
-				metrics.RecordFailure()
-				return
-			}
-			resp.Body.Close()
-			metrics.RecordSuccess(latency)
-		}(key, value)
+			defer func() { <-inFlight }()
+			execute(driver, op, intended, metrics)
+		}(op, intended)
+		intended = intended.Add(time.Duration(float64(time.Second) / stage.rateAt(intended.Sub(start))))
 	}
 	wg.Wait()
 }
 
-func performGetOperations(keys []string, rps int, duration time.Duration, metrics *Metrics) {
-	ticker := time.NewTicker(time.Second / time.Duration(rps))
-	defer ticker.Stop()
-
-	endTime := time.Now().Add(duration)
+// performClosedLoop runs the stage's number of workers, each sending requests
+// back-to-back, so the offered load adapts to the server's response time.
+func performClosedLoop(cfg *Config, stage Stage, driver Driver, next func() (operation, bool), metrics map[string]*Metrics, stop *atomic.Bool) {
+	endTime := time.Now().Add(time.Duration(stage.Duration))
+	var mu sync.Mutex
 	var wg sync.WaitGroup
 
-	for _, key := range keys {
-		if time.Now().After(endTime) {
-			break
-		}
-		<-ticker.C
+	for w := 0; w < stage.concurrency(cfg); w++ {
 		wg.Add(1)
-		go func(k string) {
+		go func() {
 			defer wg.Done()
-			start := time.Now()
-			resp, err := http.Get(fmt.Sprintf("http://localhost:8080/get?key=%s", k))
-			latency := time.Since(start)
-			if err != nil {
-				fmt.Printf("Failed to get key %s: %v\n", k, err)
-				metrics.RecordFailure()
-				return
+			for time.Now().Before(endTime) && !stop.Load() {
+				mu.Lock()
+				op, ok := next()
+				mu.Unlock()
+				if !ok {
+					return
+				}
+				execute(driver, op, time.Now(), metrics)
 			}
-			resp.Body.Close()
-			metrics.RecordSuccess(latency)
-		}(key)
+		}()
 	}
 	wg.Wait()
 }
 
+// setOperations writes every key once, in order, then runs out. In a
+// distributed run each worker only writes the keys of its own shard.
+func setOperations(cfg *Config, keys []string, values *Values) func() (operation, bool) {
+	i := cfg.Shard
+	step := 1
+	if cfg.Shards > 1 {
+		step = cfg.Shards
+	}
+	return func() (operation, bool) {
+		if i >= len(keys) {
+			return operation{}, false
+		}
+		op := operation{name: opSet, key: keys[i], value: values.For(i)}
+		i += step
+		return op, true
+	}
+}
+
+// nextGet builds a read of cfg.GetBatch keys picked by chooser.
+func nextGet(cfg *Config, keys []string, chooser KeyChooser) operation {
+	op := operation{name: opGet, key: keys[chooser.Next()]}
+	for i := 1; i < cfg.GetBatch; i++ {
+		op.batch = append(op.batch, keys[chooser.Next()])
+	}
+	return op
+}
+
+// getOperations reads keys picked by chooser.
+func getOperations(cfg *Config, keys []string, chooser KeyChooser) func() (operation, bool) {
+	return func() (operation, bool) {
+		return nextGet(cfg, keys, chooser), true
+	}
+}
+
+// mixedOperations interleaves reads and writes on keys picked by chooser,
+// with cfg.ReadRatio percent of the operations being reads.
+func mixedOperations(cfg *Config, keys []string, values *Values, chooser KeyChooser, rng *rand.Rand) func() (operation, bool) {
+	return func() (operation, bool) {
+		if rng.Intn(100) < cfg.ReadRatio {
+			return nextGet(cfg, keys, chooser), true
+		}
+		i := chooser.Next()
+		return operation{name: opSet, key: keys[i], value: values.For(i)}, true
+	}
+}
+
+// runPhase runs one phase of the workload through every stage of the load
+// profile. Each non-warmup stage is summarized and added to the report as
+// "<phase>/<stage>" (or just "<phase>" without a profile). The phase ends
+// early if next runs out of operations.
+func runPhase(cfg *Config, driver Driver, report *Report, phase string, ops []string, next func() (operation, bool)) {
+	exhausted := false
+	wrapped := func() (operation, bool) {
+		op, ok := next()
+		if !ok {
+			exhausted = true
+		}
+		return op, ok
+	}
+
+	for _, stage := range stagesFor(cfg) {
+		name := phase
+		if stage.Name != "" {
+			name = phase + "/" + stage.Name
+		}
+		metrics := make(map[string]*Metrics, len(ops))
+		for _, op := range ops {
+			metrics[op] = NewMetrics()
+		}
+
+		fmt.Printf("Starting %s operations (%s)...\n", name, stage.describe(cfg))
+		performOperations(cfg, stage, driver, wrapped, metrics)
+		if stage.Warmup {
+			fmt.Printf("Completed %s in %v (warmup, results discarded).\n", name, time.Since(metrics[ops[0]].started).Round(time.Millisecond))
+		} else {
+			fmt.Printf("Completed %s operations.\n", name)
+			for _, op := range ops {
+				metrics[op].PrintSummary(op)
+			}
+			report.AddPhase(name, metrics)
+		}
+		if exhausted {
+			fmt.Printf("No more %s operations, skipping remaining stages.\n", phase)
+			return
+		}
+	}
+}
+
+// runLoadTest runs the configured workload against cfg.Target and returns the
+// report. It is used both for local runs and by worker agents.
+func runLoadTest(cfg *Config) (*Report, error) {
+	driver, err := newDriver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer driver.Close()
+
+	keys := generateKeys(cfg.Keys)
+	values, err := newValues(cfg, keys)
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	chooser, err := newKeyChooser(cfg, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	report := NewReport(cfg)
+
+	target := fmt.Sprintf("%s (api %s)", cfg.Target, cfg.API)
+	if strings.HasPrefix(cfg.Target, "redis://") {
+		target = fmt.Sprintf("%s (RESP)", cfg.Target)
+	}
+	fmt.Printf("Target: %s, %d keys, %s loop\n", target, cfg.Keys, cfg.Mode)
+	fmt.Printf("Key distribution: %s (seed %d)\n", cfg.Distribution, cfg.Seed)
+	fmt.Printf("Values: %s\n", values.describe())
+
+	if cfg.Workload == workloadMixed {
+		fmt.Printf("Mixed workload with %d%% reads\n", cfg.ReadRatio)
+		runPhase(cfg, driver, report, "mixed", []string{opSet, opGet}, mixedOperations(cfg, keys, values, chooser, rng))
+	} else {
+		runPhase(cfg, driver, report, "set", []string{opSet}, setOperations(cfg, keys, values))
+		runPhase(cfg, driver, report, "get", []string{opGet}, getOperations(cfg, keys, chooser))
+	}
+	return report, nil
+}
+
 func main() {
-	keyValues := generateKeyValuePairs(100000)
-	keys := make([]string, 0, len(keyValues))
-	for key := range keyValues {
-		keys = append(keys, key)
-	}
-
-	setMetrics := &Metrics{}
-	getMetrics := &Metrics{}
-
-	fmt.Println("Starting SET operations...")
-	performSetOperations(keyValues, 1500, 10*time.Second, setMetrics)
-	fmt.Println("Completed SET operations.")
-	fmt.Printf("SET Metrics: Total Requests: %d, Successful: %d, Failed: %d, Average Latency: %v, Min Latency: %v, Max Latency: %v\n",
-		setMetrics.TotalRequests, setMetrics.Successful, setMetrics.Failed, setMetrics.AverageLatency(), setMetrics.MinLatency, setMetrics.MaxLatency)
-
-	fmt.Println("Starting GET operations...")
-	performGetOperations(keys, 1500, 10*time.Second, getMetrics)
-	fmt.Println("Completed GET operations.")
-	fmt.Printf("GET Metrics: Total Requests: %d, Successful: %d, Failed: %d, Average Latency: %v, Min Latency: %v, Max Latency: %v\n",
-		getMetrics.TotalRequests, getMetrics.Successful, getMetrics.Failed, getMetrics.AverageLatency(), getMetrics.MinLatency, getMetrics.MaxLatency)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		case "worker":
+			os.Exit(runWorker(os.Args[2:]))
+		}
+	}
+
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cfg.MetricsAddr != "" {
+		exporter = newPromExporter(cfg.Target)
+		exporter.serve(cfg.MetricsAddr)
+	}
+
+	var report *Report
+	if len(cfg.Workers) > 0 {
+		report, err = runCoordinator(cfg)
+	} else {
+		report, err = runLoadTest(cfg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := report.Save(cfg.ReportJSON, cfg.ReportCSV); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		os.Exit(1)
+	}
+
+	if exporter != nil && cfg.MetricsLinger > 0 {
+		fmt.Printf("Serving final metrics for %v...\n", cfg.MetricsLinger)
+		time.Sleep(cfg.MetricsLinger)
+	}
 }
@@ -2,161 +2,94 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
-	"github.com/redis/go-redis/v9"
-	_ "github.com/go-sql-driver/mysql"
+	"Strategy"
 )
 
-// Struct to hold user data
-type requestData struct {
-	Name       string `json:"name"`
-	Age        int    `json:"age"`
-	Occupation string `json:"occupation"`
-}
-
-var cache *redis.Client
-var db *sql.DB
-
-func init() {
-	// Initialize Redis client
-	cache = redis.NewClient(&redis.Options{
-		// Addr: "localhost:6379", // Update with your Redis address
-		Addr: "redis_service:6379",
-	})
-
-	// Initialize MySQL connection
-	var err error
-	// db, err = sql.Open("mysql", "root:1234@tcp(localhost:3306)/users")
-	db, err = sql.Open("mysql", "root:1234@tcp(mysql_service:3306)/users")
-	if err != nil {
-		log.Fatalf("Failed to connect to MySQL: %v", err)
-	}
-	log.Println("Mysql and Redis client init!")
-}
-
 func main() {
-	http.HandleFunc("/read-cache-aside", cacheAsideReadHandler)
-	http.HandleFunc("/write-cache-aside", cacheAsideWriteHandler)
-	log.Println("Server started at :8081")
-	err := http.ListenAndServe(":8081", nil)
-	if err != nil {
-		log.Fatalf("Server failed to start: %v", err)
-	}
-}
-
-// Handler for reading data (cache-aside pattern)
-func cacheAsideReadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var data requestData
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	err := decoder.Decode(&data)
-	if err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	ctx := context.Background()
-
-	// Check Redis cache
-	val, err := cache.Get(ctx, data.Name).Result()
-	if err == nil {
-		// Data found in cache
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(val))
-		fmt.Println("Data retrieved from cache")
-		return
-	} else if err != redis.Nil {
-		// Redis error
-		http.Error(w, "Redis error: "+err.Error(), http.StatusInternalServerError)
-		return
+	env := func(name, def string) string {
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
 	}
-
-	// Data not found in cache, retrieve from database
-	userData, err := readFromDatabase(data.Name)
-	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if userData == nil {
-		http.Error(w, "Data not found", http.StatusNotFound)
-		fmt.Println("Data not present in Database")
-		return
-	}
-
-	// Store data in Redis
-	userJSON, _ := json.Marshal(userData)
-	cache.Set(ctx, data.Name, string(userJSON), 5*time.Minute) // Cache with a 5-minute TTL
-
-	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(userJSON)
-	fmt.Println("Data retrieved from database and cached")
-}
-
-// Handler for writing data (cache-aside pattern)
-func cacheAsideWriteHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	parse := func(name, def string, parse func(string) error) {
+		if err := parse(env(name, def)); err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
 	}
-
-	var data requestData
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	err := decoder.Decode(&data)
+	var opts strategy.CacheAsideOptions
+	var bloomKeys int
+	var bloomFP float64
+	var versioned bool
+	parse("CACHE_COALESCE", "true", func(v string) (err error) { opts.Coalesce, err = strconv.ParseBool(v); return })
+	parse("CACHE_XFETCH_BETA", "0", func(v string) (err error) { opts.XFetchBeta, err = strconv.ParseFloat(v, 64); return })
+	parse("CACHE_STALE_FOR", "0s", func(v string) (err error) { opts.StaleFor, err = time.ParseDuration(v); return })
+	parse("CACHE_NEGATIVE_TTL", "30s", func(v string) (err error) { opts.NegativeTTL, err = time.ParseDuration(v); return })
+	parse("CACHE_BLOOM_KEYS", "0", func(v string) (err error) { bloomKeys, err = strconv.Atoi(v); return })
+	parse("CACHE_BLOOM_FP", "0.01", func(v string) (err error) { bloomFP, err = strconv.ParseFloat(v, 64); return })
+	parse("CACHE_VERSIONED", "true", func(v string) (err error) { versioned, err = strconv.ParseBool(v); return })
+	parse("CACHE_DOUBLE_DELETE", "0s", func(v string) (err error) { opts.DoubleDeleteAfter, err = time.ParseDuration(v); return })
+	flag.BoolVar(&opts.Coalesce, "coalesce", opts.Coalesce, "let only one database load per key be in flight (CACHE_COALESCE)")
+	flag.Float64Var(&opts.XFetchBeta, "xfetch-beta", opts.XFetchBeta, "refresh keys early with XFetch when positive, 1 is typical (CACHE_XFETCH_BETA)")
+	flag.DurationVar(&opts.StaleFor, "stale-for", opts.StaleFor, "serve expired entries this long while one request reloads them (CACHE_STALE_FOR)")
+	flag.DurationVar(&opts.NegativeTTL, "negative-ttl", opts.NegativeTTL, "cache names missing from MySQL this long, 0 disables (CACHE_NEGATIVE_TTL)")
+	flag.IntVar(&bloomKeys, "bloom-keys", bloomKeys, "reject names absent from a Bloom filter sized for this many names, 0 disables (CACHE_BLOOM_KEYS)")
+	flag.Float64Var(&bloomFP, "bloom-fp", bloomFP, "false positive rate of the Bloom filter (CACHE_BLOOM_FP)")
+	flag.BoolVar(&versioned, "versioned", versioned, "version entries so a read racing a write cannot cache the old row (CACHE_VERSIONED)")
+	flag.DurationVar(&opts.DoubleDeleteAfter, "double-delete", opts.DoubleDeleteAfter, "delete written keys again after this long, 0 disables (CACHE_DOUBLE_DELETE)")
+	flag.Parse()
+
+	cfg := strategy.ConfigFromEnv(strategy.Config{
+		RedisAddr: "redis_service:6379",
+		MySQLDSN:  "root:1234@tcp(mysql_service:3306)/users",
+		Listen:    ":8081",
+	})
+	cache := strategy.NewRedisCache(cfg.RedisAddr)
+	store, err := strategy.OpenMySQL(cfg.MySQLDSN)
 	if err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalf("Failed to connect to MySQL: %v", err)
 	}
-
-	// Write directly to the database
-	err = writeToDatabase(data)
-	if err != nil {
-		http.Error(w, "Database write error: "+err.Error(), http.StatusInternalServerError)
-		return
+	log.Println("Mysql and Redis client init!")
+	if versioned {
+		opts.Versions = cache
 	}
 
-	// Invalidate the cache for this key
-	ctx := context.Background()
-	cache.Del(ctx, data.Name)
-
-	fmt.Fprintf(w, "Data written successfully!")
-	fmt.Println("Cache invalidated for key:", data.Name)
-}
-
-// Read from MySQL database
-func readFromDatabase(name string) (*requestData, error) {
-	query := "SELECT name, age, occupation FROM users WHERE name = ?"
-	row := db.QueryRow(query, name)
-
-	var user requestData
-	err := row.Scan(&user.Name, &user.Age, &user.Occupation)
-	if err == sql.ErrNoRows {
-		return nil, nil
+	// Loaded users are cached for 5 minutes and missing names for
+	// CACHE_NEGATIVE_TTL; writes invalidate the key.
+	cacheAside := strategy.NewCacheAside(cache, store, 5*time.Minute, opts)
+	var s strategy.Strategy = cacheAside
+	var filtered *strategy.Filtered
+	if bloomKeys > 0 {
+		filter := strategy.NewBloomFilter(bloomKeys, bloomFP)
+		if err := filter.AddAll(context.Background(), store); err != nil {
+			log.Fatalf("Failed to load names into the Bloom filter: %v", err)
+		}
+		filtered = strategy.NewFiltered(cacheAside, filter)
+		s = filtered
 	}
+	http.Handle("/read-cache-aside", strategy.ReadBodyHandler(s))
+	http.Handle("/write-cache-aside", strategy.WriteHandler(s))
+	http.Handle("/stats", strategy.StatsHandler(func() any {
+		stats := struct {
+			strategy.CacheAsideStats
+			Filter *strategy.FilterStats `json:"filter,omitempty"`
+		}{CacheAsideStats: cacheAside.Stats()}
+		if filtered != nil {
+			f := filtered.Stats()
+			stats.Filter = &f
+		}
+		return stats
+	}))
+	log.Println("Server started at", cfg.Listen)
+	err = http.ListenAndServe(cfg.Listen, nil)
 	if err != nil {
-		return nil, err
+		log.Fatalf("Server failed to start: %v", err)
 	}
-	return &user, nil
 }
-
-// Write to MySQL database
-func writeToDatabase(data requestData) error {
-	query := "INSERT INTO users (name, age, occupation) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE age = ?, occupation = ?"
-	_, err := db.Exec(query, data.Name, data.Age, data.Occupation, data.Age, data.Occupation)
-	return err
-}
-
@@ -2,14 +2,18 @@ module ReadWriteBehindUsingKafka
 
 go 1.23.4
 
+require github.com/confluentinc/confluent-kafka-go v1.9.2
+
 require (
-	github.com/confluentinc/confluent-kafka-go v1.9.2
-	github.com/go-sql-driver/mysql v1.8.1
-	github.com/redis/go-redis/v9 v9.7.0
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/redis/go-redis/v9 v9.7.0 // indirect
 )
 
 require (
+	Strategy v0.0.0
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 )
+
+replace Strategy => ../../Strategy
@@ -2,111 +2,102 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"syscall"
 	"sync"
+	"syscall"
 
-	"github.com/redis/go-redis/v9"
-	_ "github.com/go-sql-driver/mysql"
+	"Strategy"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
-// Struct to hold request data
-type requestData struct {
-	Name       string `json:"name"`
-	Age        int    `json:"age"`
-	Occupation string `json:"occupation"`
-}
-
 var (
-	cache          *redis.Client
-	db             *sql.DB
-	kafkaProducer  *kafka.Producer
-	kafkaConsumer  *kafka.Consumer
 	consumerGroup  = "user-consumer-group"
 	topic          = "users"
 	workerPoolSize = 6
-
 )
 
-func init() {
-	// Initialize Redis client
-	cache = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", // No password set
-		DB:       0,  // Default DB
-	})
+// kafkaQueue is the write-behind Queue: writes are produced to the topic
+// and written to MySQL by the consumer workers.
+type kafkaQueue struct {
+	producer *kafka.Producer
+}
 
-	// Initialize MySQL connection
-	var err error
-	dsn := "root:1234@tcp(localhost:3306)/users"
-	db, err = sql.Open("mysql", dsn)
+func (q kafkaQueue) Enqueue(ctx context.Context, user strategy.User) error {
+	value, err := json.Marshal(user)
 	if err != nil {
-		log.Fatalf("Failed to connect to MySQL: %v", err)
+		return err
 	}
+	// Produce message to Kafka (acks=all for reliability)
+	return q.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          value,
+	}, nil)
+}
 
-	// Verify MySQL connection
-	err = db.Ping()
+func main() {
+	env := func(name, def string) string {
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
+	}
+	cfg := strategy.ConfigFromEnv(strategy.Config{
+		RedisAddr: "localhost:6379",
+		MySQLDSN:  "root:1234@tcp(localhost:3306)/users",
+		Listen:    ":8080",
+	})
+	cache := strategy.NewRedisCache(cfg.RedisAddr)
+	store, err := strategy.OpenMySQL(cfg.MySQLDSN)
 	if err != nil {
 		log.Fatalf("MySQL connection failed: %v", err)
 	}
 
-	// Initialize Kafka producer
-	kafkaProducer, err = kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers":  "pkc-619z3.us-east1.gcp.confluent.cloud:9092", // Replace with your bootstrap servers
-		"sasl.username":      "OAJPGNTLBH6KR2HF",  // Your Confluent Cloud API Key
-		"sasl.password":      "UpsT5OHHkk0GdFak/EEAuBYFLEkpgHvqBm6YKxwF3my2DU06OFHoWLuTFUOOa24S",  // Your Confluent Cloud API Secret
-		"security.protocol":  "SASL_SSL",
-		"sasl.mechanism":     "PLAIN",
-		"acks":               "all", // Ensure reliability
-	})
+	kafkaConfig := kafka.ConfigMap{
+		"bootstrap.servers": env("KAFKA_BOOTSTRAP_SERVERS", "pkc-619z3.us-east1.gcp.confluent.cloud:9092"),
+		"sasl.username":     env("KAFKA_API_KEY", "OAJPGNTLBH6KR2HF"),
+		"sasl.password":     env("KAFKA_API_SECRET", "UpsT5OHHkk0GdFak/EEAuBYFLEkpgHvqBm6YKxwF3my2DU06OFHoWLuTFUOOa24S"),
+		"security.protocol": "SASL_SSL",
+		"sasl.mechanism":    "PLAIN",
+	}
+	producerConfig := kafka.ConfigMap{"acks": "all"}
+	consumerConfig := kafka.ConfigMap{
+		"group.id":          consumerGroup,
+		"auto.offset.reset": "earliest", // Start reading from the earliest offset
+	}
+	for k, v := range kafkaConfig {
+		producerConfig[k] = v
+		consumerConfig[k] = v
+	}
+	producer, err := kafka.NewProducer(&producerConfig)
 	if err != nil {
 		log.Fatalf("Failed to create Kafka producer: %v", err)
 	}
-
-	// Initialize Kafka consumer
-	kafkaConsumer, err = kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers":  "pkc-619z3.us-east1.gcp.confluent.cloud:9092", // Replace with your bootstrap servers
-		"sasl.username":      "OAJPGNTLBH6KR2HF",  // Your Confluent Cloud API Key
-		"sasl.password":      "UpsT5OHHkk0GdFak/EEAuBYFLEkpgHvqBm6YKxwF3my2DU06OFHoWLuTFUOOa24S",  // Your Confluent Cloud API Secret
-		"group.id":           consumerGroup,
-		"auto.offset.reset":  "earliest", // Start reading from the earliest offset
-		"security.protocol":  "SASL_SSL",
-		"sasl.mechanism":     "PLAIN",
-	})
+	consumer, err := kafka.NewConsumer(&consumerConfig)
 	if err != nil {
 		log.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
-
-	// Subscribe to Kafka topic
-	err = kafkaConsumer.Subscribe(topic, nil)
-	if err != nil {
+	if err := consumer.Subscribe(topic, nil); err != nil {
 		log.Printf("Consumer failed to subscribe to topic: %v", err)
-		return
 	}
-
 	log.Println("Initialized Redis, MySQL, and Kafka")
-}
 
-func main() {
 	// Setup shutdown signal handling
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Start the worker pool for consuming Kafka messages
-	go startConsumerWorkers()
+	go startConsumerWorkers(consumer, store)
 
-	http.HandleFunc("/write-behind", writeBehindHandler)
-	// Start the HTTP server in a goroutine
+	s := strategy.Compose(strategy.NewReadThrough(cache, store, 0, 0), strategy.NewWriteBehind(cache, kafkaQueue{producer}, 0))
+	http.Handle("/write-behind", strategy.WriteHandler(s))
+	http.Handle("/read-behind", strategy.ReadBodyHandler(s))
 	go func() {
-		log.Println("Server started at :8080")
-		err := http.ListenAndServe(":8080", nil)
+		log.Println("Server started at", cfg.Listen)
+		err := http.ListenAndServe(cfg.Listen, nil)
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
@@ -115,89 +106,35 @@ func main() {
 	// Wait for shutdown signal
 	<-stopChan
 
-	// Graceful shutdown
-	shutdown()
-}
-
-func writeBehindHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var userData requestData
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	err := decoder.Decode(&userData)
-	if err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	ctx := context.Background()
-
-	// Write to Redis cache
-	cacheErr := cache.Set(ctx, userData.Name, fmt.Sprintf("%v", userData), 0).Err()
-	if cacheErr != nil {
-		http.Error(w, "Redis error: "+cacheErr.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Write to Kafka (asynchronously)
-	err = produceData(userData)
-	if err != nil {
-		log.Printf("Produce error: %v", err)
-		http.Error(w, "Kafka produce error", http.StatusInternalServerError)
-		return
-	}
-
-	fmt.Fprintf(w, "Data write successful!")
-}
-
-// Produce message to Kafka
-func produceData(userData requestData) error {
-	// Marshal userData to JSON
-	value, err := json.Marshal(userData)
-	if err != nil {
-		log.Printf("Failed to marshal userData to JSON: %v", err)
-		return err
-	}
-
-	// Prepare Kafka message
-	message := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          value,
-	}
-
-	// Produce message to Kafka (acks=all for reliability)
-	return kafkaProducer.Produce(message, nil)
+	log.Println("Shutting down gracefully...")
+	consumer.Close()
+	producer.Close()
+	store.Close()
+	cache.Close()
+	log.Println("Shutdown complete")
 }
 
-// Consumer worker function
-func startConsumerWorkers() {
-	// Create multiple workers to consume messages from Kafka topic
+// startConsumerWorkers runs workerPoolSize consumers writing the topic to
+// the store.
+func startConsumerWorkers(consumer *kafka.Consumer, store strategy.Store) {
 	var wg sync.WaitGroup
-
 	for i := 0; i < workerPoolSize; i++ {
 		wg.Add(1)
-		go func(workerID int) {
+		go func() {
 			defer wg.Done()
-			consumeData()
-		}(i)
+			consumeData(consumer, store)
+		}()
 	}
-
-	// Wait for all consumers to finish (if needed for graceful shutdown)
 	wg.Wait()
 }
 
-// Consumer function to read messages from Kafka and write to MySQL
-func consumeData() {
+// consumeData reads messages from Kafka, writes them to the store and
+// commits their offsets.
+func consumeData(consumer *kafka.Consumer, store strategy.Store) {
 	log.Printf("Consumer started")
 
 	for {
-		// Consume messages from Kafka
-		msg, err := kafkaConsumer.ReadMessage(-1)
+		msg, err := consumer.ReadMessage(-1)
 		if err != nil {
 			log.Printf("Consumer failed to read message: %v", err)
 			continue
@@ -205,23 +142,21 @@ func consumeData() {
 
 		log.Printf("Consumer received message: %s", string(msg.Value))
 
-		// Process the message (write to MySQL)
-		var userData requestData
-		err = json.Unmarshal(msg.Value, &userData)
+		var user strategy.User
+		err = json.Unmarshal(msg.Value, &user)
 		if err != nil {
 			log.Printf("Consumer failed to unmarshal message: %v", err)
 			continue
 		}
 
-		// Write to MySQL
-		err = writeToDatabase(userData)
+		err = store.Write(context.Background(), user)
 		if err != nil {
 			log.Printf("Consumer failed to write to database: %v", err)
 			continue
 		}
 
 		// Manually commit the offset after successful processing
-		_, err = kafkaConsumer.CommitOffsets([]kafka.TopicPartition{
+		_, err = consumer.CommitOffsets([]kafka.TopicPartition{
 			{Topic: &topic, Partition: msg.TopicPartition.Partition, Offset: msg.TopicPartition.Offset + 1},
 		})
 		if err != nil {
@@ -229,28 +164,3 @@ func consumeData() {
 		}
 	}
 }
-
-// Write to MySQL database
-func writeToDatabase(userData requestData) error {
-	query := "INSERT INTO users (name, age, occupation) VALUES (?, ?, ?)"
-	_, err := db.Exec(query, userData.Name, userData.Age, userData.Occupation)
-	return err
-}
-
-// Graceful shutdown
-func shutdown() {
-	log.Println("Shutting down gracefully...")
-	if kafkaConsumer != nil {
-		kafkaConsumer.Close()
-	}
-	if kafkaProducer != nil {
-		kafkaProducer.Close()
-	}
-	if db != nil {
-		db.Close()
-	}
-	if cache != nil {
-		cache.Close()
-	}
-	log.Println("Shutdown complete")
-}
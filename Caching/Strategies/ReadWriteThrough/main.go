@@ -2,187 +2,78 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
-	"github.com/redis/go-redis/v9"
-	_ "github.com/go-sql-driver/mysql"
+	"Strategy"
 )
 
-// Struct to hold request data
-type requestData struct {
-	Name       string `json:"name"`
-	Age        int    `json:"age"`
-	Occupation string `json:"occupation"`
-}
-
-var cache *redis.Client
-var db *sql.DB
-
-func init() {
-	// Initialize Redis client
-	cache = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379", // Update with your Redis address
-	})
-
-	// Initialize MySQL connection
-	var err error
-	db, err = sql.Open("mysql", "root:1234@tcp(localhost:3306)/users")
-	if err != nil {
-		log.Fatalf("Failed to connect to MySQL: %v", err)
-	}
-	log.Println("Mysql and Redis client init!")
-}
-
 func main() {
-	http.HandleFunc("/write-through", writeThroughHandler)
-	http.HandleFunc("/read-through", readThroughHandler)
-	log.Println("Server started at :8080")
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Fatalf("Server failed to start: %v", err)
-	}
-}
-
-func readThroughHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var data requestData
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	err := decoder.Decode(&data)
-	if err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	ctx := context.Background()
-
-	// Check Redis cache
-	val, err := cache.Get(ctx, data.Name).Result()
-	if err == nil {
-		fmt.Fprintln(w, val)
-		fmt.Println("Data present in Cache")
-		return
-	} else if err != redis.Nil {
-		http.Error(w, "Redis error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// If not found in Redis, fetch from MySQL
-	userData, err := readFromDatabase(data.Name)
-	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if userData == nil {
-		http.Error(w, "Name not present", http.StatusNotFound)
-		fmt.Println("Data not present in Database")
-		return
-	}
-
-	// Store the result in Redis for future lookups
-	userJSON, _ := json.Marshal(userData)
-	cache.Set(ctx, data.Name, userJSON, 0)
-
-	// Return the result
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(userJSON)
-}
-
-// Read from MySQL database
-func readFromDatabase(name string) (*requestData, error) {
-	query := "SELECT name, age, occupation FROM users WHERE name = ?"
-	row := db.QueryRow(query, name)
-
-	var user requestData
-	err := row.Scan(&user.Name, &user.Age, &user.Occupation)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-
-// Handler for the /write-through endpoint
-func writeThroughHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		fmt.Println("Method not allowed")
-		return
+	env := func(name, def string) string {
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		return def
 	}
-
-	var data requestData
-	decoder := json.NewDecoder(r.Body)
-	defer r.Body.Close()
-
-	err := decoder.Decode(&data)
-	if err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
-		fmt.Println("Invalid request body")
-		return
-	}
-
-	ctx := context.Background()
-
-	// Write to Redis cache
-	cacheErr := cache.Set(ctx, data.Name, fmt.Sprintf("%v", data), 0).Err()
-	if cacheErr != nil {
-		http.Error(w, "Redis error: "+cacheErr.Error(), http.StatusInternalServerError)
-		fmt.Println("Redis error")
-		return
-	}
-
-	// Write synchronously to MySQL
-	err = writeToDatabase(data)
-	if err != nil {
-		log.Printf("MySQL write error: %v", err)
-		fmt.Println("MySQL write error")
+	parse := func(name, def string, parse func(string) error) {
+		if err := parse(env(name, def)); err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
 	}
-
-	fmt.Fprintf(w, "Data write successful!")
-}
-
-// Write to MySQL database
-func writeToDatabase(data requestData) error {
-	query := "INSERT INTO users (name, age, occupation) VALUES (?, ?, ?)"
-	_, err := db.Exec(query, data.Name, data.Age, data.Occupation)
-	return err
-}
-
-// Initialize Redis and MySQL
-func init() {
-	// Initialize Redis client
-	cache = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", 
-		DB:       0,
+	var negativeTTL time.Duration
+	var bloomKeys int
+	var bloomFP float64
+	parse("CACHE_NEGATIVE_TTL", "30s", func(v string) (err error) { negativeTTL, err = time.ParseDuration(v); return })
+	parse("CACHE_BLOOM_KEYS", "0", func(v string) (err error) { bloomKeys, err = strconv.Atoi(v); return })
+	parse("CACHE_BLOOM_FP", "0.01", func(v string) (err error) { bloomFP, err = strconv.ParseFloat(v, 64); return })
+	flag.DurationVar(&negativeTTL, "negative-ttl", negativeTTL, "cache names missing from MySQL this long, 0 disables (CACHE_NEGATIVE_TTL)")
+	flag.IntVar(&bloomKeys, "bloom-keys", bloomKeys, "reject names absent from a Bloom filter sized for this many names, 0 disables (CACHE_BLOOM_KEYS)")
+	flag.Float64Var(&bloomFP, "bloom-fp", bloomFP, "false positive rate of the Bloom filter (CACHE_BLOOM_FP)")
+	flag.Parse()
+
+	cfg := strategy.ConfigFromEnv(strategy.Config{
+		RedisAddr: "localhost:6379",
+		MySQLDSN:  "root:1234@tcp(localhost:3306)/users",
+		Listen:    ":8080",
 	})
-
-	// Initialize MySQL connection
-	var err error
-	dsn := "root:1234@tcp(localhost:3306)/users"
-
-	db, err = sql.Open("mysql", dsn)
+	cache := strategy.NewRedisCache(cfg.RedisAddr)
+	store, err := strategy.OpenMySQL(cfg.MySQLDSN)
 	if err != nil {
-		log.Fatalf("Failed to connect to MySQL: %v", err)
+		log.Fatalf("MySQL connection failed: %v", err)
 	}
+	log.Println("Initialized Redis and MySQL")
 
-	// Verify MySQL connection
-	err = db.Ping()
+	readThrough := strategy.NewReadThrough(cache, store, 0, negativeTTL)
+	s := strategy.Compose(readThrough, strategy.NewWriteThrough(cache, store, 0))
+	var filtered *strategy.Filtered
+	if bloomKeys > 0 {
+		filter := strategy.NewBloomFilter(bloomKeys, bloomFP)
+		if err := filter.AddAll(context.Background(), store); err != nil {
+			log.Fatalf("Failed to load names into the Bloom filter: %v", err)
+		}
+		filtered = strategy.NewFiltered(s, filter)
+		s = filtered
+	}
+	http.Handle("/write-through", strategy.WriteHandler(s))
+	http.Handle("/read-through", strategy.ReadBodyHandler(s))
+	http.Handle("/stats", strategy.StatsHandler(func() any {
+		stats := struct {
+			strategy.LoadingCacheStats
+			Filter *strategy.FilterStats `json:"filter,omitempty"`
+		}{LoadingCacheStats: readThrough.Stats()}
+		if filtered != nil {
+			f := filtered.Stats()
+			stats.Filter = &f
+		}
+		return stats
+	}))
+	log.Println("Server started at", cfg.Listen)
+	err = http.ListenAndServe(cfg.Listen, nil)
 	if err != nil {
-		log.Fatalf("MySQL connection failed: %v", err)
+		log.Fatalf("Server failed to start: %v", err)
 	}
-
-	log.Println("Initialized Redis and MySQL")
 }
@@ -0,0 +1,126 @@
+package strategy
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// BloomFilter is a set of names that can answer "definitely absent" without
+// a lookup. It has no false negatives, and false positives at about the rate
+// it was sized for as long as it holds no more names than expected. Names
+// cannot be removed.
+type BloomFilter struct {
+	bits   []atomic.Uint64
+	m      uint64
+	hashes uint64
+	added  atomic.Uint64
+}
+
+// NewBloomFilter sizes a filter for n names with false positive rate p.
+func NewBloomFilter(n int, p float64) *BloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &BloomFilter{bits: make([]atomic.Uint64, (m+63)/64), m: m, hashes: k}
+}
+
+// locations derives the filter's k bit positions for name from two halves
+// of one 64-bit hash (Kirsch-Mitzenmacher double hashing).
+func (f *BloomFilter) locations(name string, visit func(bit uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	for i := uint64(0); i < f.hashes; i++ {
+		if !visit((h1 + i*h2) % f.m) {
+			return
+		}
+	}
+}
+
+func (f *BloomFilter) Add(name string) {
+	f.locations(name, func(bit uint64) bool {
+		f.bits[bit/64].Or(1 << (bit % 64))
+		return true
+	})
+	f.added.Add(1)
+}
+
+// MayContain reports false only for names that were never added.
+func (f *BloomFilter) MayContain(name string) bool {
+	found := true
+	f.locations(name, func(bit uint64) bool {
+		found = f.bits[bit/64].Load()&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
+
+// NameLister is a Store that can enumerate its names, so a filter can be
+// filled with them.
+type NameLister interface {
+	Names(ctx context.Context, fn func(name string)) error
+}
+
+// AddAll adds every name in store to the filter.
+func (f *BloomFilter) AddAll(ctx context.Context, store NameLister) error {
+	return store.Names(ctx, f.Add)
+}
+
+// Filtered puts a BloomFilter in front of a strategy. Reads of names the
+// filter has never seen return ErrNotFound without touching the cache or
+// the store; writes add the name to the filter before writing, so a name is
+// never in the store without being in the filter.
+//
+// The filter only learns of writes made through this process. With several
+// servers writing to one store, names written elsewhere are reported
+// missing until the filter is rebuilt.
+type Filtered struct {
+	Strategy
+	filter             *BloomFilter
+	rejected, admitted atomic.Uint64
+}
+
+// FilterStats counts the reads a Filtered strategy answered itself.
+type FilterStats struct {
+	Rejected uint64 `json:"rejected"`
+	Admitted uint64 `json:"admitted"`
+	// Names is how many adds the filter has seen, including repeats.
+	Names uint64 `json:"names"`
+}
+
+func NewFiltered(s Strategy, filter *BloomFilter) *Filtered {
+	return &Filtered{Strategy: s, filter: filter}
+}
+
+func (f *Filtered) Read(ctx context.Context, name string) (*User, Source, error) {
+	if !f.filter.MayContain(name) {
+		f.rejected.Add(1)
+		return nil, FromFilter, ErrNotFound
+	}
+	f.admitted.Add(1)
+	return f.Strategy.Read(ctx, name)
+}
+
+func (f *Filtered) Write(ctx context.Context, user User) error {
+	f.filter.Add(user.Name)
+	return f.Strategy.Write(ctx, user)
+}
+
+func (f *Filtered) Stats() FilterStats {
+	return FilterStats{
+		Rejected: f.rejected.Load(),
+		Admitted: f.admitted.Load(),
+		Names:    f.filter.added.Load(),
+	}
+}
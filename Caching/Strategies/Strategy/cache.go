@@ -0,0 +1,129 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Entry is a cached value with the time it has left to live. TTL is zero
+// for a value that does not expire.
+type Entry struct {
+	Value string
+	TTL   time.Duration
+}
+
+// Cache is the fast tier in front of a Store. A miss is reported with found
+// false rather than an error; a ttl of zero stores a value without expiry.
+type Cache interface {
+	Get(ctx context.Context, key string) (entry Entry, found bool, err error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// RedisCache is a Cache backed by a single Redis server.
+type RedisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache returns a Cache on the Redis server at addr. The connection
+// is made lazily on first use.
+func NewRedisCache(addr string) *RedisCache {
+	return &RedisCache{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+// Get fetches the value and its remaining TTL in one round trip.
+func (c *RedisCache) Get(ctx context.Context, key string) (Entry, bool, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	entry := Entry{Value: get.Val()}
+	if ttl := pttl.Val(); ttl > 0 {
+		entry.TTL = ttl
+	}
+	return entry, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// Versioner keeps a version counter per key next to a Cache, which
+// CacheAside uses to tell entries loaded before the latest write from
+// entries loaded after it. Keys that were never invalidated are at version
+// zero.
+type Versioner interface {
+	// GetVersioned is Get that also returns the key's current version, read
+	// in the same round trip.
+	GetVersioned(ctx context.Context, key string) (entry Entry, version int64, found bool, err error)
+	// Invalidate increments the key's version and deletes the key in one
+	// atomic step. The version is kept for keep after the last write when
+	// keep is positive, and forever otherwise; it must outlive every entry
+	// tagged with an older version, or such an entry turns valid again.
+	Invalidate(ctx context.Context, key string, keep time.Duration) error
+}
+
+// versionKey names the counter of key.
+func versionKey(key string) string {
+	return "version:" + key
+}
+
+func (c *RedisCache) GetVersioned(ctx context.Context, key string) (Entry, int64, bool, error) {
+	var get, version *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		version = pipe.Get(ctx, versionKey(key))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return Entry{}, 0, false, err
+	}
+	var v int64
+	if version.Err() == nil {
+		if v, err = version.Int64(); err != nil {
+			return Entry{}, 0, false, err
+		}
+	}
+	if errors.Is(get.Err(), redis.Nil) {
+		return Entry{}, v, false, nil
+	}
+	entry := Entry{Value: get.Val()}
+	if ttl := pttl.Val(); ttl > 0 {
+		entry.TTL = ttl
+	}
+	return entry, v, true, nil
+}
+
+func (c *RedisCache) Invalidate(ctx context.Context, key string, keep time.Duration) error {
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, versionKey(key))
+		if keep > 0 {
+			pipe.PExpire(ctx, versionKey(key), keep)
+		}
+		pipe.Del(ctx, key)
+		return nil
+	})
+	return err
+}
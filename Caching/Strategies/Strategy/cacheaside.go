@@ -0,0 +1,306 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// CacheAside leaves the cache to the application: reads check the cache,
+// load misses from the store and populate the cache with a TTL; writes go
+// to the store and invalidate the cached copy.
+//
+// When a popular key expires, every concurrent reader misses at once and
+// goes to the store. CacheAsideOptions enables three defences against that
+// stampede: coalescing concurrent loads of a key into one, refreshing keys
+// probabilistically before they expire (XFetch), and serving an expired
+// value for a grace period while one reader reloads it.
+//
+// A reader that misses, reads the old row and is overtaken by a write
+// caches the old row after the write deleted the key, and it stays cached
+// until it expires. Versioned entries and a delayed second delete close
+// that window; see CacheAsideOptions.
+type CacheAside struct {
+	cache Cache
+	store Store
+	ttl   time.Duration
+	opts  CacheAsideOptions
+
+	flight flight
+	// loadTime is a moving average of store load durations in nanoseconds,
+	// XFetch's estimate of how long a recomputation takes.
+	loadTime atomic.Int64
+	stats    cacheAsideCounters
+}
+
+// CacheAsideOptions configures stampede protection. The zero value reads
+// like a plain cache-aside: every miss loads from the store.
+type CacheAsideOptions struct {
+	// Coalesce lets only one store load per key be in flight; concurrent
+	// readers of the key wait for its result.
+	Coalesce bool
+	// XFetchBeta enables XFetch probabilistic early expiration when
+	// positive. A hit reloads the key early with a probability that rises as
+	// its expiry approaches, scaled by the time a load takes; 1 is the
+	// recommended value and larger values refresh earlier.
+	XFetchBeta float64
+	// StaleFor keeps entries in the cache this long past their TTL. A read
+	// of such an expired entry returns it and reloads it in the background.
+	StaleFor time.Duration
+	// NegativeTTL caches the absence of a name for this long when positive,
+	// so repeated reads of a missing name do not each query the store. It is
+	// usually much shorter than the TTL of values; a write of the name
+	// replaces the negative entry like any other.
+	NegativeTTL time.Duration
+	// Versions, usually the RedisCache also passed as the cache, makes
+	// entries versioned: a write increments the key's version along with
+	// deleting it, a load tags the entry with the version current before it
+	// read the store, and reads treat entries with an older tag as misses.
+	// An entry loaded from a row read before a write can then no longer be
+	// served after it. Versions expire once no entry or load tagged with an
+	// older one can be left; see versionLifetime.
+	Versions Versioner
+	// DoubleDeleteAfter deletes the key a second time this long after a
+	// write, removing an old row a racing reader cached in between. It
+	// narrows the race rather than closing it: a load slower than the delay
+	// still caches the old row.
+	DoubleDeleteAfter time.Duration
+}
+
+// CacheAsideStats counts how reads were served.
+type CacheAsideStats struct {
+	Hits uint64 `json:"hits"`
+	// StaleHits were served an expired value while it was revalidated.
+	StaleHits uint64 `json:"stale_hits"`
+	Misses    uint64 `json:"misses"`
+	// NegativeHits were answered not found from a cached absence.
+	NegativeHits uint64 `json:"negative_hits"`
+	// EarlyRefreshes are hits XFetch chose to reload before expiry.
+	EarlyRefreshes uint64 `json:"early_refreshes"`
+	// Loads are store reads made; Coalesced are reads that waited for a
+	// load another reader had started instead of making their own.
+	Loads      uint64 `json:"loads"`
+	Coalesced  uint64 `json:"coalesced"`
+	LoadErrors uint64 `json:"load_errors"`
+	// StaleVersions are entries rejected because a write happened after
+	// they were loaded; DelayedDeletes are second deletes made.
+	StaleVersions  uint64 `json:"stale_versions"`
+	DelayedDeletes uint64 `json:"delayed_deletes"`
+}
+
+type cacheAsideCounters struct {
+	hits, staleHits, misses, negativeHits atomic.Uint64
+	earlyRefreshes                        atomic.Uint64
+	loads, coalesced, loadErrors          atomic.Uint64
+	staleVersions, delayedDeletes         atomic.Uint64
+}
+
+func NewCacheAside(cache Cache, store Store, ttl time.Duration, opts CacheAsideOptions) *CacheAside {
+	if ttl == 0 {
+		// Entries that never expire are never stale.
+		opts.StaleFor = 0
+	}
+	return &CacheAside{cache: cache, store: store, ttl: ttl, opts: opts}
+}
+
+func (s *CacheAside) Read(ctx context.Context, name string) (*User, Source, error) {
+	entry, version, found, err := s.get(ctx, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("cache: %w", err)
+	}
+	if found && s.opts.Versions != nil {
+		if tagged, _, _ := untag(entry.Value); tagged != version {
+			s.stats.staleVersions.Add(1)
+			found = false
+		}
+	}
+	if found && isNegative(entry.Value) {
+		s.stats.negativeHits.Add(1)
+		return nil, FromCache, ErrNotFound
+	}
+	if found {
+		// The entry outlives its TTL by StaleFor, so this is the time left
+		// before it goes stale.
+		fresh := entry.TTL - s.opts.StaleFor
+		switch {
+		case entry.TTL > 0 && fresh <= 0:
+			s.stats.staleHits.Add(1)
+			s.revalidate(ctx, name, version)
+		case entry.TTL > 0 && s.expireEarly(fresh):
+			s.stats.earlyRefreshes.Add(1)
+			user, err := s.load(ctx, name, version)
+			return user, FromStore, err
+		default:
+			s.stats.hits.Add(1)
+		}
+		user, err := decode(entry.Value)
+		return user, FromCache, err
+	}
+
+	s.stats.misses.Add(1)
+	user, err := s.load(ctx, name, version)
+	return user, FromStore, err
+}
+
+// get reads name from the cache, with its version when entries are
+// versioned.
+func (s *CacheAside) get(ctx context.Context, name string) (Entry, int64, bool, error) {
+	if s.opts.Versions != nil {
+		return s.opts.Versions.GetVersioned(ctx, name)
+	}
+	entry, found, err := s.cache.Get(ctx, name)
+	return entry, 0, found, err
+}
+
+// expireEarly implements the XFetch test: reload when
+// -delta × beta × ln(rand) reaches the time left, where delta is the
+// average load time.
+func (s *CacheAside) expireEarly(left time.Duration) bool {
+	delta := s.loadTime.Load()
+	if s.opts.XFetchBeta <= 0 || delta == 0 {
+		return false
+	}
+	return float64(delta)*s.opts.XFetchBeta*-math.Log(1-rand.Float64()) >= float64(left)
+}
+
+// load reads name from the store and caches it, coalescing with a load of
+// the same key already in flight when enabled. version is the key's
+// version seen before the load.
+func (s *CacheAside) load(ctx context.Context, name string, version int64) (*User, error) {
+	if !s.opts.Coalesce {
+		// Bounded like a coalesced load, which versionLifetime relies on.
+		ctx, cancel := context.WithTimeout(ctx, backgroundTimeout)
+		defer cancel()
+		return s.loadFromStore(ctx, name, version)
+	}
+	c, leader := s.flight.start(ctx, s.flightKey(name, version), func(ctx context.Context) (*User, error) {
+		return s.loadFromStore(ctx, name, version)
+	})
+	if !leader {
+		s.stats.coalesced.Add(1)
+	}
+	return c.wait(ctx)
+}
+
+// revalidate reloads name in the background, unless a load of it is
+// already in flight. Revalidation is always coalesced, otherwise every
+// reader of a stale key would start its own.
+func (s *CacheAside) revalidate(ctx context.Context, name string, version int64) {
+	_, leader := s.flight.start(ctx, s.flightKey(name, version), func(ctx context.Context) (*User, error) {
+		return s.loadFromStore(ctx, name, version)
+	})
+	if !leader {
+		s.stats.coalesced.Add(1)
+	}
+}
+
+// flightKey keys coalesced loads by name and, when entries are versioned,
+// by the version they started at, so a reader that has seen a write never
+// waits for a load that read the store before it.
+func (s *CacheAside) flightKey(name string, version int64) string {
+	return s.tag(version, name)
+}
+
+func (s *CacheAside) loadFromStore(ctx context.Context, name string, version int64) (*User, error) {
+	s.stats.loads.Add(1)
+	start := time.Now()
+	user, err := s.store.Read(ctx, name)
+	if errors.Is(err, ErrNotFound) && s.opts.NegativeTTL > 0 {
+		s.cache.Set(ctx, name, s.tag(version, negativeEntry), s.opts.NegativeTTL)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			s.stats.loadErrors.Add(1)
+		}
+		return nil, err
+	}
+	s.observeLoad(time.Since(start))
+	value, err := encode(user)
+	if err != nil {
+		return nil, err
+	}
+	ttl := s.ttl
+	if ttl > 0 {
+		ttl += s.opts.StaleFor
+	}
+	// A failed populate only costs another miss, so the read still succeeds.
+	s.cache.Set(ctx, name, s.tag(version, value), ttl)
+	return user, nil
+}
+
+// versionLifetime is how long a key's version is kept after a write: the
+// longest an entry lives in the cache, plus backgroundTimeout, the longest
+// a load that read the store before the write can take to populate it. Entries that never
+// expire need their versions forever, so it is 0 then.
+func (s *CacheAside) versionLifetime() time.Duration {
+	if s.ttl == 0 {
+		return 0
+	}
+	return max(s.ttl+s.opts.StaleFor, s.opts.NegativeTTL) + backgroundTimeout
+}
+
+// tag marks value with version when entries are versioned.
+func (s *CacheAside) tag(version int64, value string) string {
+	if s.opts.Versions == nil {
+		return value
+	}
+	return tag(version, value)
+}
+
+// observeLoad folds d into the load time average with a weight of 1/8.
+func (s *CacheAside) observeLoad(d time.Duration) {
+	old := s.loadTime.Load()
+	if old == 0 {
+		s.loadTime.Store(int64(d))
+		return
+	}
+	s.loadTime.Store(old + (int64(d)-old)/8)
+}
+
+func (s *CacheAside) Write(ctx context.Context, user User) error {
+	if err := s.store.Write(ctx, user); err != nil {
+		return err
+	}
+	invalidate := s.cache.Delete
+	if s.opts.Versions != nil {
+		invalidate = func(ctx context.Context, key string) error {
+			return s.opts.Versions.Invalidate(ctx, key, s.versionLifetime())
+		}
+	}
+	if err := invalidate(ctx, user.Name); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	if s.opts.DoubleDeleteAfter > 0 {
+		time.AfterFunc(s.opts.DoubleDeleteAfter, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+			defer cancel()
+			if err := s.cache.Delete(ctx, user.Name); err != nil {
+				log.Printf("Delayed delete of %q failed: %v", user.Name, err)
+				return
+			}
+			s.stats.delayedDeletes.Add(1)
+		})
+	}
+	return nil
+}
+
+// Stats returns the read counters since the strategy was created.
+func (s *CacheAside) Stats() CacheAsideStats {
+	return CacheAsideStats{
+		Hits:           s.stats.hits.Load(),
+		StaleHits:      s.stats.staleHits.Load(),
+		Misses:         s.stats.misses.Load(),
+		NegativeHits:   s.stats.negativeHits.Load(),
+		EarlyRefreshes: s.stats.earlyRefreshes.Load(),
+		Loads:          s.stats.loads.Load(),
+		Coalesced:      s.stats.coalesced.Load(),
+		LoadErrors:     s.stats.loadErrors.Load(),
+		StaleVersions:  s.stats.staleVersions.Load(),
+		DelayedDeletes: s.stats.delayedDeletes.Load(),
+	}
+}
@@ -0,0 +1,25 @@
+package strategy
+
+import "os"
+
+// Config holds the addresses a strategy server connects to and listens on.
+type Config struct {
+	RedisAddr string
+	MySQLDSN  string
+	Listen    string
+}
+
+// ConfigFromEnv overrides the fields of def with REDIS_ADDR, MYSQL_DSN and
+// LISTEN_ADDR when they are set.
+func ConfigFromEnv(def Config) Config {
+	for name, field := range map[string]*string{
+		"REDIS_ADDR":  &def.RedisAddr,
+		"MYSQL_DSN":   &def.MySQLDSN,
+		"LISTEN_ADDR": &def.Listen,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+	return def
+}
@@ -0,0 +1,63 @@
+package strategy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backgroundTimeout bounds store calls that outlive the request which
+// caused them.
+const backgroundTimeout = 10 * time.Second
+
+// flight coalesces concurrent loads of the same key: the first caller starts
+// the load and later callers wait for its result instead of starting their
+// own.
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done chan struct{}
+	user *User
+	err  error
+}
+
+// start returns the load in flight for key, starting load in a goroutine if
+// there is none; leader reports whether this call started it. The load runs
+// on a context that is not cancelled with ctx, so a leader that gives up
+// does not fail the callers waiting on it; it is bounded by
+// backgroundTimeout instead.
+func (f *flight) start(ctx context.Context, key string, load func(context.Context) (*User, error)) (c *call, leader bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.calls[key]; ok {
+		return c, false
+	}
+	if f.calls == nil {
+		f.calls = make(map[string]*call)
+	}
+	c = &call{done: make(chan struct{})}
+	f.calls[key] = c
+	go func() {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+		c.user, c.err = load(loadCtx)
+		cancel()
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(c.done)
+	}()
+	return c, true
+}
+
+// wait returns the result of c, or ctx's error if ctx ends first.
+func (c *call) wait(ctx context.Context) (*User, error) {
+	select {
+	case <-c.done:
+		return c.user, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
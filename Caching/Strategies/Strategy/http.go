@@ -0,0 +1,90 @@
+package strategy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ReadBodyHandler serves POST reads naming the user in a JSON User body.
+func ReadBodyHandler(s Reader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var data User
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		read(w, r, s, data.Name)
+	}
+}
+
+// ReadQueryHandler serves GET reads naming the user in the name query
+// parameter.
+func ReadQueryHandler(s Reader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
+			return
+		}
+		read(w, r, s, name)
+	}
+}
+
+func read(w http.ResponseWriter, r *http.Request, s Reader, name string) {
+	user, source, err := s.Read(r.Context(), name)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		log.Printf("Data for %q not found (answered by %s)", name, source)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Read error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+	log.Printf("Data for %q retrieved from %s", name, source)
+}
+
+// WriteHandler serves POST writes of a JSON User body.
+func WriteHandler(s Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var data User
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Write(r.Context(), data); err != nil {
+			http.Error(w, "Write error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Data written successfully!")
+	}
+}
+
+// StatsHandler serves the counters returned by stats as JSON.
+func StatsHandler(stats func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats())
+	}
+}
@@ -0,0 +1,69 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// ErrQueueClosed is returned by Enqueue after Close.
+var ErrQueueClosed = errors.New("queue closed")
+
+// ChannelQueue is an in-process Queue: a buffered channel drained into the
+// store by a pool of goroutines. Queued writes do not survive a crash.
+type ChannelQueue struct {
+	store   Store
+	writes  chan User
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// NewChannelQueue starts workers goroutines writing to store from a queue
+// holding up to size writes. Enqueue blocks while the queue is full.
+func NewChannelQueue(store Store, workers, size int) *ChannelQueue {
+	q := &ChannelQueue{store: store, writes: make(chan User, size)}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *ChannelQueue) Enqueue(ctx context.Context, user User) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.writes <- user:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *ChannelQueue) work() {
+	defer q.workers.Done()
+	for user := range q.writes {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		if err := q.store.Write(ctx, user); err != nil {
+			log.Printf("Write-behind of %q failed: %v", user.Name, err)
+		}
+		cancel()
+	}
+}
+
+// Close stops accepting writes and waits for the queued ones to reach the
+// store.
+func (q *ChannelQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.writes)
+	}
+	q.mu.Unlock()
+	q.workers.Wait()
+}
@@ -0,0 +1,320 @@
+package racetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"Engine"
+	"Strategy"
+)
+
+// loadTimeout is how long the strategy package lets a store load run.
+const loadTimeout = 10 * time.Second
+
+// memStore is a Store kept in memory. After armPause, the next Read signals
+// read once it has its row and waits for resume before returning it, like a
+// slow database response overtaken by a write.
+type memStore struct {
+	mu     sync.Mutex
+	rows   map[string]strategy.User
+	pause  bool
+	read   chan struct{}
+	resume chan struct{}
+	delay  time.Duration
+}
+
+func newMemStore() *memStore {
+	return &memStore{rows: make(map[string]strategy.User)}
+}
+
+func (s *memStore) Read(ctx context.Context, name string) (*strategy.User, error) {
+	s.mu.Lock()
+	user, ok := s.rows[name]
+	pause := s.pause
+	s.pause = false
+	delay := s.delay
+	s.mu.Unlock()
+	if pause {
+		s.read <- struct{}{}
+		<-s.resume
+	}
+	if delay > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(delay))))
+	}
+	if !ok {
+		return nil, strategy.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *memStore) Write(ctx context.Context, user strategy.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows[user.Name] = user
+	return nil
+}
+
+func (s *memStore) armPause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pause = true
+	s.read = make(chan struct{})
+	s.resume = make(chan struct{})
+}
+
+// setDelay makes every Read take a random time up to delay.
+func (s *memStore) setDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+// handlerClient drives a strategy through the CacheAside server's read and
+// write handlers.
+type handlerClient struct {
+	srv *httptest.Server
+}
+
+func newHandlerClient(t *testing.T, s strategy.Strategy) *handlerClient {
+	mux := http.NewServeMux()
+	mux.Handle("/read-cache-aside", strategy.ReadBodyHandler(s))
+	mux.Handle("/write-cache-aside", strategy.WriteHandler(s))
+	c := &handlerClient{srv: httptest.NewServer(mux)}
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *handlerClient) post(path string, user strategy.User) (*http.Response, error) {
+	body, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(c.srv.URL+path, "application/json", bytes.NewReader(body))
+}
+
+// age reads name and returns the age it is served with.
+func (c *handlerClient) age(name string) (int, error) {
+	resp, err := c.post("/read-cache-aside", strategy.User{Name: name})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("read %q: %s", name, resp.Status)
+	}
+	var user strategy.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return 0, err
+	}
+	return user.Age, nil
+}
+
+func (c *handlerClient) write(user strategy.User) error {
+	resp, err := c.post("/write-cache-aside", user)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("write %q: %s", user.Name, resp.Status)
+	}
+	return nil
+}
+
+// TestCacheAsideWriteRace replays the cache-aside write race through the
+// HTTP handlers: a read misses and fetches the old row, a write updates the
+// store and invalidates the key, and the read then caches the old row.
+func TestCacheAsideWriteRace(t *testing.T) {
+	srv, err := engine.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	cache := strategy.NewRedisCache(srv.Addr())
+	t.Cleanup(func() { cache.Close() })
+	// The handlers log every request.
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	const doubleDelete = 50 * time.Millisecond
+	tests := []struct {
+		name string
+		opts strategy.CacheAsideOptions
+		// fixed is whether the old row must be gone once the write and the
+		// racing read are done.
+		fixed bool
+		// freshDuringLoad is whether a read made after the write, while the
+		// racing load is still in the store, must get the new row.
+		freshDuringLoad bool
+	}{
+		{"delete", strategy.CacheAsideOptions{Coalesce: true}, false, false},
+		{"double-delete", strategy.CacheAsideOptions{Coalesce: true, DoubleDeleteAfter: doubleDelete}, true, false},
+		{"versioned", strategy.CacheAsideOptions{Coalesce: true, Versions: cache}, true, true},
+		{"versioned-uncoalesced", strategy.CacheAsideOptions{Versions: cache}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			c := newHandlerClient(t, strategy.NewCacheAside(cache, store, time.Minute, tt.opts))
+			// Leave room for the second delete before reading the outcome.
+			settle := 2 * tt.opts.DoubleDeleteAfter
+
+			t.Run("paused", func(t *testing.T) {
+				name := tt.name + ":paused"
+				store.Write(context.Background(), strategy.User{Name: name, Age: 1})
+
+				store.armPause()
+				first := make(chan error, 1)
+				go func() {
+					_, err := c.age(name)
+					first <- err
+				}()
+				<-store.read
+				if err := c.write(strategy.User{Name: name, Age: 2}); err != nil {
+					t.Fatal(err)
+				}
+
+				type result struct {
+					age int
+					err error
+				}
+				during := make(chan result, 1)
+				go func() {
+					age, err := c.age(name)
+					during <- result{age, err}
+				}()
+				// A read that joined the paused load only returns once it
+				// is resumed.
+				var r result
+				got := false
+				if tt.freshDuringLoad {
+					select {
+					case r = <-during:
+						got = true
+					case <-time.After(5 * time.Second):
+					}
+				}
+				close(store.resume)
+				if !got {
+					r = <-during
+				}
+				if r.err != nil {
+					t.Fatal(r.err)
+				}
+				if tt.freshDuringLoad && r.age != 2 {
+					t.Errorf("read during the racing load got age %d, want 2", r.age)
+				}
+				if err := <-first; err != nil {
+					t.Fatal(err)
+				}
+
+				time.Sleep(settle)
+				age, err := c.age(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case tt.fixed && age != 2:
+					t.Errorf("read after the write got age %d, want 2", age)
+				case !tt.fixed && age == 2:
+					t.Errorf("read after the write got age 2; the race was not reproduced")
+				}
+			})
+
+			t.Run("stress", func(t *testing.T) {
+				if testing.Short() {
+					t.Skip("races with random timing take a few seconds")
+				}
+				// Loads are shorter than the double delete's delay, so both
+				// fixes must hold.
+				const n = 100
+				load := doubleDelete / 5
+				store.setDelay(load)
+				for i := 0; i < n; i++ {
+					name := fmt.Sprintf("%s:stress-%d", tt.name, i)
+					store.Write(context.Background(), strategy.User{Name: name, Age: 1})
+					read := make(chan error, 1)
+					go func() {
+						_, err := c.age(name)
+						read <- err
+					}()
+					time.Sleep(time.Duration(rand.Int63n(int64(load))))
+					if err := c.write(strategy.User{Name: name, Age: 2}); err != nil {
+						t.Fatal(err)
+					}
+					if err := <-read; err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				time.Sleep(settle)
+				stale := 0
+				for i := 0; i < n; i++ {
+					age, err := c.age(fmt.Sprintf("%s:stress-%d", tt.name, i))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if age != 2 {
+						stale++
+					}
+				}
+				if tt.fixed && stale > 0 {
+					t.Errorf("%d/%d names served the old row", stale, n)
+				}
+				t.Logf("%d/%d names served the old row", stale, n)
+			})
+		})
+	}
+}
+
+// TestVersionExpiry checks that a versioned write leaves a version key that
+// expires after every entry it guards.
+func TestVersionExpiry(t *testing.T) {
+	ctx := context.Background()
+	srv, err := engine.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	cache := strategy.NewRedisCache(srv.Addr())
+	t.Cleanup(func() { cache.Close() })
+	rdb := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	const ttl, staleFor = time.Minute, 30 * time.Second
+	s := strategy.NewCacheAside(cache, newMemStore(), ttl, strategy.CacheAsideOptions{StaleFor: staleFor, Versions: cache})
+	if err := s.Write(ctx, strategy.User{Name: "alice", Age: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Read(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry is gone after ttl+staleFor; the version must still be
+	// there then, and gone a little later.
+	srv.Advance(ttl + staleFor)
+	if n := rdb.Exists(ctx, "alice").Val(); n != 0 {
+		t.Fatalf("entry still cached after ttl+StaleFor")
+	}
+	if v := rdb.Get(ctx, "version:alice").Val(); v != "1" {
+		t.Errorf("version after the entry expired = %q, want 1", v)
+	}
+	srv.Advance(loadTimeout)
+	if n := rdb.Exists(ctx, "version:alice").Val(); n != 0 {
+		t.Errorf("version key still present after ttl+StaleFor+%v", loadTimeout)
+	}
+}
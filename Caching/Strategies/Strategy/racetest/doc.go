@@ -0,0 +1,4 @@
+// Package racetest tests the strategies against an in-process Engine. It
+// is a module of its own so that Engine, which only these tests need, stays
+// out of the Strategy module and the servers built on it.
+package racetest
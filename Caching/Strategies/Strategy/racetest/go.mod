@@ -0,0 +1,21 @@
+module racetest
+
+go 1.23.4
+
+require (
+	Engine v0.0.0
+	Strategy v0.0.0
+	github.com/redis/go-redis/v9 v9.7.0
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+)
+
+replace (
+	Engine => ../../../Engine
+	Strategy => ..
+)
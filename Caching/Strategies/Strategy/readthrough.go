@@ -0,0 +1,112 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Loader produces the value of a key that is not cached.
+type Loader func(ctx context.Context, key string) (string, error)
+
+// LoadingCache is a Cache that fills its own misses from a Loader, so its
+// users never talk to the store directly.
+type LoadingCache struct {
+	Cache
+	load        Loader
+	ttl         time.Duration
+	negativeTTL time.Duration
+	stats       loadingCacheCounters
+}
+
+// LoadingCacheStats counts how Load calls were answered.
+type LoadingCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// NegativeHits were answered not found from a cached absence.
+	NegativeHits uint64 `json:"negative_hits"`
+}
+
+type loadingCacheCounters struct {
+	hits, misses, negativeHits atomic.Uint64
+}
+
+// NewLoadingCache returns a cache that loads misses with load and keeps
+// them for ttl. When negativeTTL is positive, a key for which load returns
+// ErrNotFound is cached as absent for that long.
+func NewLoadingCache(cache Cache, load Loader, ttl, negativeTTL time.Duration) *LoadingCache {
+	return &LoadingCache{Cache: cache, load: load, ttl: ttl, negativeTTL: negativeTTL}
+}
+
+// Load returns the cached value of key, loading and caching it on a miss.
+func (c *LoadingCache) Load(ctx context.Context, key string) (string, Source, error) {
+	entry, found, err := c.Get(ctx, key)
+	if err != nil {
+		return "", "", fmt.Errorf("cache: %w", err)
+	}
+	if found && isNegative(entry.Value) {
+		c.stats.negativeHits.Add(1)
+		return "", FromCache, ErrNotFound
+	}
+	if found {
+		c.stats.hits.Add(1)
+		return entry.Value, FromCache, nil
+	}
+	c.stats.misses.Add(1)
+	value, err := c.load(ctx, key)
+	if errors.Is(err, ErrNotFound) && c.negativeTTL > 0 {
+		c.Set(ctx, key, negativeEntry, c.negativeTTL)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	c.Set(ctx, key, value, c.ttl)
+	return value, FromStore, nil
+}
+
+func (c *LoadingCache) Stats() LoadingCacheStats {
+	return LoadingCacheStats{
+		Hits:         c.stats.hits.Load(),
+		Misses:       c.stats.misses.Load(),
+		NegativeHits: c.stats.negativeHits.Load(),
+	}
+}
+
+// ReadThrough reads only from a LoadingCache that is backed by the store.
+// It differs from CacheAside in who fills the cache, not in the requests
+// made: the cache layer owns the loading.
+type ReadThrough struct {
+	cache *LoadingCache
+}
+
+// NewReadThrough caches users for ttl and, when negativeTTL is positive,
+// names the store does not have for negativeTTL.
+func NewReadThrough(cache Cache, store Store, ttl, negativeTTL time.Duration) *ReadThrough {
+	return &ReadThrough{cache: NewLoadingCache(cache, storeLoader(store), ttl, negativeTTL)}
+}
+
+func (s *ReadThrough) Read(ctx context.Context, name string) (*User, Source, error) {
+	value, source, err := s.cache.Load(ctx, name)
+	if err != nil {
+		return nil, source, err
+	}
+	user, err := decode(value)
+	return user, source, err
+}
+
+func (s *ReadThrough) Stats() LoadingCacheStats {
+	return s.cache.Stats()
+}
+
+// storeLoader loads the cache representation of a user from store.
+func storeLoader(store Store) Loader {
+	return func(ctx context.Context, name string) (string, error) {
+		user, err := store.Read(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return encode(user)
+	}
+}
@@ -0,0 +1,82 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RefreshAhead reads like ReadThrough, but a hit on an entry whose remaining
+// TTL has dropped below threshold × ttl reloads it in the background, so hot
+// keys are replaced before they expire instead of missing.
+type RefreshAhead struct {
+	cache     *LoadingCache
+	ttl       time.Duration
+	threshold float64
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+}
+
+// NewRefreshAhead returns a reader that refreshes entries with less than
+// threshold (between 0 and 1) of their TTL left.
+func NewRefreshAhead(cache Cache, store Store, ttl time.Duration, threshold float64) *RefreshAhead {
+	return &RefreshAhead{
+		cache:      NewLoadingCache(cache, storeLoader(store), ttl, 0),
+		ttl:        ttl,
+		threshold:  threshold,
+		refreshing: make(map[string]bool),
+	}
+}
+
+func (s *RefreshAhead) Read(ctx context.Context, name string) (*User, Source, error) {
+	entry, found, err := s.cache.Get(ctx, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("cache: %w", err)
+	}
+	if !found {
+		value, source, err := s.cache.Load(ctx, name)
+		if err != nil {
+			return nil, "", err
+		}
+		user, err := decode(value)
+		return user, source, err
+	}
+	if entry.TTL > 0 && float64(entry.TTL) < s.threshold*float64(s.ttl) {
+		s.refresh(name)
+	}
+	user, err := decode(entry.Value)
+	return user, FromCache, err
+}
+
+// refresh reloads name unless a refresh of it is already running. It does
+// not use the request's context, which ends with the response.
+func (s *RefreshAhead) refresh(name string) {
+	s.mu.Lock()
+	if s.refreshing[name] {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing[name] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, name)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundTimeout)
+		defer cancel()
+		value, err := s.cache.load(ctx, name)
+		if err != nil {
+			log.Printf("Refresh of %q failed: %v", name, err)
+			return
+		}
+		if err := s.cache.Set(ctx, name, value, s.ttl); err != nil {
+			log.Printf("Refresh of %q failed: %v", name, err)
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package strategy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Store is the system of record behind the cache.
+type Store interface {
+	// Read returns ErrNotFound when no user has the name.
+	Read(ctx context.Context, name string) (*User, error)
+	Write(ctx context.Context, user User) error
+}
+
+// MySQLStore keeps users in the users table:
+//
+//	CREATE TABLE users (
+//	    id INT AUTO_INCREMENT PRIMARY KEY,
+//	    name VARCHAR(255) NOT NULL,
+//	    age INT NOT NULL,
+//	    occupation VARCHAR(255) NOT NULL
+//	);
+type MySQLStore struct {
+	db *sql.DB
+}
+
+// OpenMySQL connects to the database named by dsn and checks that it is
+// reachable.
+func OpenMySQL(dsn string) (*MySQLStore, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MySQLStore{db: db}, nil
+}
+
+func (s *MySQLStore) Read(ctx context.Context, name string) (*User, error) {
+	query := "SELECT name, age, occupation FROM users WHERE name = ?"
+	var user User
+	err := s.db.QueryRowContext(ctx, query, name).Scan(&user.Name, &user.Age, &user.Occupation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Write inserts the user, or updates it when name is a unique key of the
+// table and the row exists.
+func (s *MySQLStore) Write(ctx context.Context, user User) error {
+	query := "INSERT INTO users (name, age, occupation) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE age = ?, occupation = ?"
+	_, err := s.db.ExecContext(ctx, query, user.Name, user.Age, user.Occupation, user.Age, user.Occupation)
+	return err
+}
+
+// Names calls fn with the name of every row, for filling a BloomFilter.
+func (s *MySQLStore) Names(ctx context.Context, fn func(name string)) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM users")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		fn(name)
+	}
+	return rows.Err()
+}
+
+func (s *MySQLStore) Close() error {
+	return s.db.Close()
+}
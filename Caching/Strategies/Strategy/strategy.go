@@ -0,0 +1,128 @@
+// Package strategy implements the caching strategies demonstrated under
+// Caching/Strategies on top of two small interfaces, so each strategy server
+// only wires a Cache and a Store together and every strategy runs the same
+// cache, database and HTTP code.
+//
+//	cache := strategy.NewRedisCache("localhost:6379")
+//	store, err := strategy.OpenMySQL("root:1234@tcp(localhost:3306)/users")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	s := strategy.NewCacheAside(cache, store, 5*time.Minute, strategy.CacheAsideOptions{})
+//	http.Handle("/read", strategy.ReadBodyHandler(s))
+//	http.Handle("/write", strategy.WriteHandler(s))
+//
+// Read strategies (CacheAside, ReadThrough, RefreshAhead) and write
+// strategies (CacheAside, WriteThrough, WriteAround, WriteBehind) can be
+// combined with Compose.
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned by stores and strategies for a name with no row.
+var ErrNotFound = errors.New("not found")
+
+// User is the document every strategy server reads and writes, keyed by
+// name.
+type User struct {
+	Name       string `json:"name"`
+	Age        int    `json:"age"`
+	Occupation string `json:"occupation"`
+}
+
+// Source tells where a read was served from.
+type Source string
+
+const (
+	FromCache Source = "cache"
+	FromStore Source = "store"
+	// FromFilter marks a read a Filtered strategy rejected itself.
+	FromFilter Source = "filter"
+)
+
+// Reader is the read half of a strategy.
+type Reader interface {
+	Read(ctx context.Context, name string) (*User, Source, error)
+}
+
+// Writer is the write half of a strategy.
+type Writer interface {
+	Write(ctx context.Context, user User) error
+}
+
+// Strategy reads and writes users through a cache in front of a store.
+type Strategy interface {
+	Reader
+	Writer
+}
+
+// Compose pairs a read strategy with a write strategy, e.g. read-through
+// with write-behind.
+func Compose(r Reader, w Writer) Strategy {
+	return composed{r, w}
+}
+
+type composed struct {
+	Reader
+	Writer
+}
+
+// negativeEntry is cached for a name the store does not have. It is not
+// valid JSON, so it cannot be mistaken for a user.
+const negativeEntry = "<not found>"
+
+// isNegative reports whether value, versioned or not, is a negativeEntry.
+func isNegative(value string) bool {
+	_, value, _ = untag(value)
+	return value == negativeEntry
+}
+
+// tag prefixes a cached value with the key version it was loaded at, as
+// "#<version>#<value>". Neither JSON nor negativeEntry starts with '#'.
+func tag(version int64, value string) string {
+	return "#" + strconv.FormatInt(version, 10) + "#" + value
+}
+
+// untag splits a value written by tag. Untagged values are returned as they
+// are with tagged false.
+func untag(value string) (version int64, rest string, tagged bool) {
+	if !strings.HasPrefix(value, "#") {
+		return 0, value, false
+	}
+	end := strings.IndexByte(value[1:], '#')
+	if end < 0 {
+		return 0, value, false
+	}
+	version, err := strconv.ParseInt(value[1:end+1], 10, 64)
+	if err != nil {
+		return 0, value, false
+	}
+	return version, value[end+2:], true
+}
+
+// encode and decode convert users to and from the JSON kept in the cache.
+// Every strategy caches the same representation, so a key written by one
+// can be read by another; decode ignores a version tag and returns
+// ErrNotFound for a negative entry.
+func encode(user *User) (string, error) {
+	b, err := json.Marshal(user)
+	return string(b), err
+}
+
+func decode(value string) (*User, error) {
+	_, value, _ = untag(value)
+	if value == negativeEntry {
+		return nil, ErrNotFound
+	}
+	var user User
+	if err := json.Unmarshal([]byte(value), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
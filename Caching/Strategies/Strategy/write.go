@@ -0,0 +1,80 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WriteThrough writes the store and then the cache before returning, so a
+// successful write is immediately readable from the cache. Writing the store
+// first keeps values the store rejected out of the cache.
+type WriteThrough struct {
+	cache Cache
+	store Store
+	ttl   time.Duration
+}
+
+func NewWriteThrough(cache Cache, store Store, ttl time.Duration) *WriteThrough {
+	return &WriteThrough{cache: cache, store: store, ttl: ttl}
+}
+
+func (s *WriteThrough) Write(ctx context.Context, user User) error {
+	if err := s.store.Write(ctx, user); err != nil {
+		return err
+	}
+	return setUser(ctx, s.cache, &user, s.ttl)
+}
+
+// WriteAround writes only the store. A cached copy of the user is left as
+// it is until it expires, which is the staleness the strategy trades for
+// not filling the cache with data that may never be read.
+type WriteAround struct {
+	store Store
+}
+
+func NewWriteAround(store Store) *WriteAround {
+	return &WriteAround{store: store}
+}
+
+func (s *WriteAround) Write(ctx context.Context, user User) error {
+	return s.store.Write(ctx, user)
+}
+
+// Queue carries write-behind writes to the store.
+type Queue interface {
+	Enqueue(ctx context.Context, user User) error
+}
+
+// WriteBehind writes the cache and hands the store write to a Queue,
+// returning before the store has it. Writes lost from the queue are lost.
+type WriteBehind struct {
+	cache Cache
+	queue Queue
+	ttl   time.Duration
+}
+
+func NewWriteBehind(cache Cache, queue Queue, ttl time.Duration) *WriteBehind {
+	return &WriteBehind{cache: cache, queue: queue, ttl: ttl}
+}
+
+func (s *WriteBehind) Write(ctx context.Context, user User) error {
+	if err := setUser(ctx, s.cache, &user, s.ttl); err != nil {
+		return err
+	}
+	if err := s.queue.Enqueue(ctx, user); err != nil {
+		return fmt.Errorf("queue: %w", err)
+	}
+	return nil
+}
+
+func setUser(ctx context.Context, cache Cache, user *User, ttl time.Duration) error {
+	value, err := encode(user)
+	if err != nil {
+		return err
+	}
+	if err := cache.Set(ctx, user.Name, value, ttl); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	return nil
+}
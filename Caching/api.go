@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// API describes how to build SET and GET requests for one of the servers
+// under Caching/. The cache gateway takes a JSON map on /set and a key query
+// parameter on /get; the strategy servers take the requestData document keyed
+// by name.
+type API struct {
+	NewSet func(target, key, value string) (*http.Request, error)
+	// NewGet builds a read for keys. Only APIs with MultiGet set accept more
+	// than one key per request.
+	NewGet   func(target string, keys []string) (*http.Request, error)
+	MultiGet bool
+	// Misses counts the keys a successful GET response reported as not
+	// found. It is nil for APIs that signal misses with MissStatus.
+	Misses func(body []byte) int
+	// MissStatus is the status of a GET response for a key the server does
+	// not have. Such responses count every key read as a miss rather than
+	// as a failure. It is zero for APIs that report misses in the body.
+	MissStatus int
+}
+
+// requestData mirrors the user document accepted by the Caching/Strategies servers.
+type requestData struct {
+	Name       string `json:"name"`
+	Age        int    `json:"age"`
+	Occupation string `json:"occupation"`
+}
+
+var apis = map[string]API{
+	"kv": {
+		NewSet: func(target, key, value string) (*http.Request, error) {
+			return newJSONRequest(http.MethodPost, target+"/set", map[string]string{key: value})
+		},
+		NewGet: func(target string, keys []string) (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, target+"/get?"+url.Values{"key": keys}.Encode(), nil)
+		},
+		MultiGet: true,
+		Misses: func(body []byte) int {
+			return bytes.Count(body, []byte(`"found":false`))
+		},
+	},
+	"cache-aside": {
+		NewSet:     newStrategyWrite("/write-cache-aside"),
+		NewGet:     newStrategyRead("/read-cache-aside"),
+		MissStatus: http.StatusNotFound,
+	},
+	"write-around": {
+		NewSet: newStrategyWrite("/write-around"),
+		NewGet: func(target string, keys []string) (*http.Request, error) {
+			return http.NewRequest(http.MethodGet, target+"/read?name="+url.QueryEscape(keys[0]), nil)
+		},
+		MissStatus: http.StatusNotFound,
+	},
+	"read-write-through": {
+		NewSet:     newStrategyWrite("/write-through"),
+		NewGet:     newStrategyRead("/read-through"),
+		MissStatus: http.StatusNotFound,
+	},
+	"write-behind": {
+		NewSet:     newStrategyWrite("/write-behind"),
+		NewGet:     newStrategyRead("/read-behind"),
+		MissStatus: http.StatusNotFound,
+	},
+}
+
+func apiNames() []string {
+	names := make([]string, 0, len(apis))
+	for name := range apis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newJSONRequest(method, url string, payload interface{}) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func newStrategyWrite(path string) func(target, key, value string) (*http.Request, error) {
+	return func(target, key, value string) (*http.Request, error) {
+		data := requestData{Name: key, Age: len(value) % 100, Occupation: value}
+		return newJSONRequest(http.MethodPost, target+path, data)
+	}
+}
+
+func newStrategyRead(path string) func(target string, keys []string) (*http.Request, error) {
+	return func(target string, keys []string) (*http.Request, error) {
+		return newJSONRequest(http.MethodPost, target+path, requestData{Name: keys[0]})
+	}
+}
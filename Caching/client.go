@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// httpDriver drives the HTTP API of a cache server through one shared client,
+// so connections are pooled across requests and phases.
+type httpDriver struct {
+	client *http.Client
+	api    API
+	target string
+}
+
+func newHTTPDriver(cfg *Config) *httpDriver {
+	return &httpDriver{client: newHTTPClient(cfg), api: apis[cfg.API], target: cfg.Target}
+}
+
+// newHTTPClient builds a client tuned for load generation. The default
+// transport keeps only two idle connections per host, so at high request
+// rates most requests would pay for a fresh TCP connection; here the idle
+// pool is sized to the configured concurrency.
+func newHTTPClient(cfg *Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	maxIdle := cfg.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = cfg.Concurrency
+	}
+
+	if cfg.HTTP2 && !strings.HasPrefix(cfg.Target, "https://") {
+		// Plain-text HTTP/2 (h2c) with prior knowledge: all requests are
+		// multiplexed over a single connection per host.
+		return &http.Client{
+			Timeout: cfg.RequestTimeout,
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+			},
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        maxIdle,
+		MaxIdleConnsPerHost: maxIdle,
+		IdleConnTimeout:     90 * time.Second,
+		DisableKeepAlives:   !cfg.KeepAlive,
+		ForceAttemptHTTP2:   cfg.HTTP2,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+	return &http.Client{Timeout: cfg.RequestTimeout, Transport: transport}
+}
+
+func (d *httpDriver) newRequest(op operation) (*http.Request, error) {
+	if op.name == opSet {
+		return d.api.NewSet(d.target, op.key, op.value)
+	}
+	return d.api.NewGet(d.target, op.keys())
+}
+
+func (d *httpDriver) Do(op operation, metrics *Metrics) outcome {
+	req, err := d.newRequest(op)
+	if err != nil {
+		return failed(errOther, 0, err)
+	}
+	resp, err := d.client.Do(withConnTrace(req, metrics))
+	if err != nil {
+		return failed(classifyError(err), 0, err)
+	}
+	// Read the whole body so the connection can go back to the pool.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return failed(classifyError(err), 0, err)
+	}
+	if op.name == opGet && d.api.MissStatus != 0 && resp.StatusCode == d.api.MissStatus {
+		return outcome{status: resp.StatusCode, misses: len(op.keys())}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return failed(errHTTPStatus, resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status))
+	}
+	out := outcome{status: resp.StatusCode}
+	if op.name == opGet && d.api.Misses != nil {
+		out.misses = d.api.Misses(body)
+	}
+	return out
+}
+
+func (d *httpDriver) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// withConnTrace attaches a trace to req that reports whether the request got
+// a reused connection from the pool or had to dial a new one.
+func withConnTrace(req *http.Request, metrics *Metrics) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.RecordConn(info.Reused)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
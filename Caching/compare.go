@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// comparedMetric is one number compared between two reports. higherIsBetter
+// decides which direction of change counts as a regression; getOnly metrics
+// are skipped for SET operations.
+type comparedMetric struct {
+	name           string
+	value          func(*OperationSummary) float64
+	higherIsBetter bool
+	getOnly        bool
+}
+
+var comparedMetrics = []comparedMetric{
+	{"throughput_rps", func(s *OperationSummary) float64 { return s.Throughput }, true, false},
+	{"hit_rate", func(s *OperationSummary) float64 { return s.HitRate }, true, true},
+	{"error_rate", func(s *OperationSummary) float64 { return s.ErrorRate }, false, false},
+	{"p50_us", func(s *OperationSummary) float64 { return s.Latency.P50 }, false, false},
+	{"p90_us", func(s *OperationSummary) float64 { return s.Latency.P90 }, false, false},
+	{"p99_us", func(s *OperationSummary) float64 { return s.Latency.P99 }, false, false},
+	{"p99_9_us", func(s *OperationSummary) float64 { return s.Latency.P999 }, false, false},
+}
+
+// runCompare implements "loadtest compare [-threshold pct] base.json new.json".
+// It prints every compared metric per phase and operation and returns a
+// non-zero exit code when any of them regressed by more than the threshold,
+// or when a phase or operation of the base report is missing from the new
+// one, since a workload that stopped running must not pass as unchanged.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	threshold := fs.Float64("threshold", 10, "percentage change beyond which a worse result is flagged as a regression")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: loadtest compare [-threshold pct] base.json new.json")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	base, err := loadReport(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load base report: %v\n", err)
+		return 2
+	}
+	current, err := loadReport(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load new report: %v\n", err)
+		return 2
+	}
+
+	regressions, missing := 0, 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tOP\tMETRIC\tBASE\tNEW\tCHANGE\t")
+	for _, basePhase := range base.Phases {
+		newPhase := findPhase(current, basePhase.Name)
+		if newPhase == nil {
+			fmt.Fprintf(tw, "%s\t\t\t\t\tmissing in new report\tREGRESSION\n", basePhase.Name)
+			missing++
+			continue
+		}
+		for _, op := range sortedOperations(basePhase.Operations) {
+			newOp, ok := newPhase.Operations[op]
+			if !ok {
+				fmt.Fprintf(tw, "%s\t%s\t\t\t\tmissing in new report\tREGRESSION\n", basePhase.Name, op)
+				missing++
+				continue
+			}
+			for _, metric := range comparedMetrics {
+				if metric.getOnly && op != opGet {
+					continue
+				}
+				before, after := metric.value(basePhase.Operations[op]), metric.value(newOp)
+				change := percentChange(before, after)
+				verdict := ""
+				if isRegression(change, metric.higherIsBetter, *threshold) {
+					verdict = "REGRESSION"
+					regressions++
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%.2f\t%s\t%s\n",
+					basePhase.Name, op, metric.name, before, after, formatChange(change), verdict)
+			}
+		}
+	}
+	tw.Flush()
+
+	if missing > 0 {
+		fmt.Printf("%d phase(s) or operation(s) missing in new report\n", missing)
+	}
+	if regressions > 0 {
+		fmt.Printf("%d regression(s) beyond %.1f%%\n", regressions, *threshold)
+	}
+	if missing > 0 || regressions > 0 {
+		return 1
+	}
+	fmt.Printf("No regressions beyond %.1f%%\n", *threshold)
+	return 0
+}
+
+func findPhase(r *Report, name string) *PhaseReport {
+	for i := range r.Phases {
+		if r.Phases[i].Name == name {
+			return &r.Phases[i]
+		}
+	}
+	return nil
+}
+
+// percentChange returns the relative change from before to after in percent.
+// A change from zero has no percentage and is +Inf, so that, e.g., errors
+// appearing in the new run always count as a regression.
+func percentChange(before, after float64) float64 {
+	if before == 0 {
+		if after == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (after - before) / before * 100
+}
+
+// formatChange renders a change from percentChange; one from zero is "new".
+func formatChange(change float64) string {
+	if math.IsInf(change, 1) {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", change)
+}
+
+func isRegression(change float64, higherIsBetter bool, threshold float64) bool {
+	if higherIsBetter {
+		return change < -threshold
+	}
+	return change > threshold
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	workloadSequential = "sequential"
+	workloadMixed      = "mixed"
+
+	modeOpen   = "open"
+	modeClosed = "closed"
+)
+
+// Config holds everything that shapes a load test run. All fields come from
+// command-line flags so the same binary can target any of the cache servers.
+type Config struct {
+	Target      string        `json:"target"`
+	API         string        `json:"api"`
+	Keys        int           `json:"keys"`
+	ValueSize   int           `json:"value_size"`
+	Rate        int           `json:"rate"`
+	Duration    time.Duration `json:"duration_ns"`
+	Concurrency int           `json:"concurrency"`
+	Mode        string        `json:"mode"`
+	Workload    string        `json:"workload"`
+	ReadRatio   int           `json:"read_ratio"`
+	GetBatch    int           `json:"get_batch"`
+
+	ValueDist    string  `json:"value_dist"`
+	ValueMin     int     `json:"value_min"`
+	ValueMax     int     `json:"value_max"`
+	ValueSigma   float64 `json:"value_sigma"`
+	ValueContent string  `json:"value_content"`
+	Payload      string  `json:"payload"`
+
+	Distribution string  `json:"distribution"`
+	ZipfSkew     float64 `json:"zipf_skew"`
+	HotKeys      float64 `json:"hot_keys"`
+	HotOps       float64 `json:"hot_ops"`
+	Seed         int64   `json:"seed"`
+
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host"`
+	KeepAlive           bool          `json:"keep_alive"`
+	HTTP2               bool          `json:"http2"`
+	RequestTimeout      time.Duration `json:"request_timeout_ns"`
+
+	Profile *Profile `json:"profile,omitempty"`
+
+	// Workers lists worker agents to coordinate; Shard and Shards identify
+	// the slice of the workload a worker runs.
+	Workers []string `json:"workers,omitempty"`
+	Shard   int      `json:"shard,omitempty"`
+	Shards  int      `json:"shards,omitempty"`
+
+	ReportJSON  string `json:"-"`
+	ReportCSV   string `json:"-"`
+	MetricsAddr string `json:"-"`
+
+	MetricsLinger time.Duration `json:"-"`
+}
+
+func parseConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&cfg.Target, "target", "http://localhost:8080", "server under test: http(s):// URL of a cache server, or redis://host:port to speak RESP to the cache directly")
+	fs.StringVar(&cfg.API, "api", "kv", "server API to drive: "+strings.Join(apiNames(), ", "))
+	fs.IntVar(&cfg.Keys, "keys", 100000, "number of distinct keys")
+	fs.IntVar(&cfg.ValueSize, "value-size", 0, "value size in bytes for the fixed distribution, median size for lognormal (0 keeps the short value-N strings)")
+	fs.StringVar(&cfg.ValueDist, "value-dist", sizeFixed, "value size distribution: fixed, uniform (-value-min to -value-max) or lognormal (median -value-size)")
+	fs.IntVar(&cfg.ValueMin, "value-min", 0, "smallest value size in bytes for the uniform and lognormal distributions")
+	fs.IntVar(&cfg.ValueMax, "value-max", 0, "largest value size in bytes for the uniform and lognormal distributions (0 leaves lognormal unbounded)")
+	fs.Float64Var(&cfg.ValueSigma, "value-sigma", 1, "spread of the lognormal value sizes (standard deviation of the log size)")
+	fs.StringVar(&cfg.ValueContent, "value-content", contentText, "value content: text (value-N padded with x), random (incompressible) or compressible")
+	fs.StringVar(&cfg.Payload, "payload", payloadRaw, "raw values, or json documents shaped like the strategy servers' requestData")
+	fs.IntVar(&cfg.Rate, "rate", 1500, "requests per second (open loop only)")
+	fs.DurationVar(&cfg.Duration, "duration", 10*time.Second, "duration of each phase")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 1000, "maximum requests in flight (open loop) or number of workers (closed loop)")
+	fs.StringVar(&cfg.Mode, "mode", modeOpen, "open (fixed arrival rate, latency from intended send time) or closed (workers send back-to-back)")
+	fs.StringVar(&cfg.Workload, "workload", workloadSequential, "sequential (SET phase then GET phase) or mixed (reads and writes interleaved)")
+	fs.IntVar(&cfg.ReadRatio, "read-ratio", 80, "percentage of reads in the mixed workload, e.g. 80 for 80/20")
+	fs.IntVar(&cfg.GetBatch, "get-batch", 1, "keys read per GET (multi-key /get for the kv API, MGET for redis://)")
+	fs.StringVar(&cfg.Distribution, "distribution", distSequential, "key popularity for reads and mixed workloads: "+strings.Join(distributions, ", "))
+	fs.Float64Var(&cfg.ZipfSkew, "zipf-skew", 0.99, "skew of the zipfian distribution (higher is more skewed, must not be 1)")
+	fs.Float64Var(&cfg.HotKeys, "hot-keys", 0.2, "fraction of keys in the hot set for the hotspot distribution")
+	fs.Float64Var(&cfg.HotOps, "hot-ops", 0.8, "fraction of accesses that go to the hot set for the hotspot distribution")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "random seed for key selection (0 picks one from the clock)")
+	fs.IntVar(&cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", 0, "idle connections kept per host (0 uses -concurrency)")
+	fs.BoolVar(&cfg.KeepAlive, "keep-alive", true, "reuse connections between requests")
+	fs.BoolVar(&cfg.HTTP2, "http2", false, "use HTTP/2 (h2c with prior knowledge for http:// targets)")
+	fs.DurationVar(&cfg.RequestTimeout, "timeout", 10*time.Second, "per-request timeout (0 disables it)")
+	profile := fs.String("profile", "", "JSON file with load stages (warmup, ramps, soak); overrides -duration and -rate")
+	fs.StringVar(&cfg.ReportJSON, "report-json", "", "write a JSON report to this path")
+	fs.StringVar(&cfg.ReportCSV, "report-csv", "", "write a CSV time series and summary to this path")
+	workers := fs.String("workers", "", "comma-separated worker addresses (host:port) to coordinate instead of generating load locally")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "serve live Prometheus metrics on this address, e.g. :9100 (disabled when empty)")
+	fs.DurationVar(&cfg.MetricsLinger, "metrics-linger", 0, "keep serving metrics this long after the run so the final values get scraped")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *workers != "" {
+		cfg.Workers = strings.Split(*workers, ",")
+	}
+	if *profile != "" {
+		p, err := loadProfile(*profile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Profile = p
+	}
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	if _, ok := apis[cfg.API]; !ok {
+		return fmt.Errorf("unknown api %q, expected one of: %s", cfg.API, strings.Join(apiNames(), ", "))
+	}
+	if cfg.Mode != modeOpen && cfg.Mode != modeClosed {
+		return fmt.Errorf("unknown mode %q, expected %s or %s", cfg.Mode, modeOpen, modeClosed)
+	}
+	if cfg.Workload != workloadSequential && cfg.Workload != workloadMixed {
+		return fmt.Errorf("unknown workload %q, expected %s or %s", cfg.Workload, workloadSequential, workloadMixed)
+	}
+	if cfg.Keys <= 0 {
+		return fmt.Errorf("keys must be positive, got %d", cfg.Keys)
+	}
+	if cfg.Rate <= 0 {
+		return fmt.Errorf("rate must be positive, got %d", cfg.Rate)
+	}
+	if cfg.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive, got %d", cfg.Concurrency)
+	}
+	if cfg.GetBatch < 1 {
+		return fmt.Errorf("get-batch must be at least 1, got %d", cfg.GetBatch)
+	}
+	if cfg.GetBatch > 1 && !apis[cfg.API].MultiGet && !strings.HasPrefix(cfg.Target, "redis://") {
+		return fmt.Errorf("api %s reads one key per request, get-batch must be 1", cfg.API)
+	}
+	switch cfg.ValueDist {
+	case sizeFixed:
+	case sizeUniform:
+		if cfg.ValueMin < 0 || cfg.ValueMax < cfg.ValueMin {
+			return fmt.Errorf("uniform value sizes need 0 <= value-min <= value-max, got %d and %d", cfg.ValueMin, cfg.ValueMax)
+		}
+	case sizeLogNormal:
+		if cfg.ValueSize <= 0 || cfg.ValueSigma <= 0 {
+			return fmt.Errorf("lognormal value sizes need a positive value-size and value-sigma")
+		}
+	default:
+		return fmt.Errorf("unknown value-dist %q, expected %s, %s or %s", cfg.ValueDist, sizeFixed, sizeUniform, sizeLogNormal)
+	}
+	if cfg.ValueContent != contentText && cfg.ValueContent != contentRandom && cfg.ValueContent != contentCompressible {
+		return fmt.Errorf("unknown value-content %q, expected %s, %s or %s", cfg.ValueContent, contentText, contentRandom, contentCompressible)
+	}
+	if cfg.Payload != payloadRaw && cfg.Payload != payloadJSON {
+		return fmt.Errorf("unknown payload %q, expected %s or %s", cfg.Payload, payloadRaw, payloadJSON)
+	}
+	if cfg.Payload == payloadJSON && cfg.API != "kv" && !strings.HasPrefix(cfg.Target, "redis://") {
+		return fmt.Errorf("api %s already sends requestData documents, payload must be %s", cfg.API, payloadRaw)
+	}
+	if len(cfg.Workers) > 0 && cfg.MetricsAddr != "" {
+		// Workers report only when they are done, so the coordinator would
+		// serve empty series for the whole run.
+		return fmt.Errorf("metrics-addr cannot be combined with workers, which only report when they are done")
+	}
+	if cfg.ReadRatio < 0 || cfg.ReadRatio > 100 {
+		return fmt.Errorf("read-ratio must be between 0 and 100, got %d", cfg.ReadRatio)
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	cfg.Target = strings.TrimRight(cfg.Target, "/")
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Distributed runs: one coordinator process (a normal run with -workers)
+// splits the workload into shards and hands one to each worker agent
+// ("loadtest worker") over net/rpc. Every worker runs its shard with the
+// regular runner and sends back its raw recorders; the coordinator merges the
+// histograms and counters phase by phase into a single report.
+
+// startDelay gives every worker time to receive its shard before the common
+// start time, so the workers begin sending load together.
+const startDelay = 2 * time.Second
+
+// ShardArgs is the work handed to a worker.
+type ShardArgs struct {
+	Config  Config
+	StartAt time.Time
+}
+
+// ShardResult carries a worker's recorders back to the coordinator.
+type ShardResult struct {
+	Phases []PhaseSnapshot
+}
+
+// PhaseSnapshot is the wire form of one phase (or stage) of a run.
+type PhaseSnapshot struct {
+	Name     string
+	Finished time.Time
+	Metrics  map[string]MetricsSnapshot
+}
+
+// MetricsSnapshot is the wire form of Metrics.
+type MetricsSnapshot struct {
+	TotalRequests int
+	Successful    int
+	Failed        int
+	Lookups       int
+	Misses        int
+	ConnsNew      int
+	ConnsReused   int
+	ValueBytes    int64
+	StatusCodes   map[int]int
+	Errors        map[string]int
+	Latency       *Histogram
+	ServiceTime   *Histogram
+	Started       time.Time
+	Series        []IntervalSnapshot
+}
+
+type IntervalSnapshot struct {
+	Requests int
+	Failed   int
+	Latency  *Histogram
+}
+
+// Worker is the RPC service exposed by "loadtest worker". It runs one shard
+// at a time.
+type Worker struct {
+	mu sync.Mutex
+}
+
+func (w *Worker) Run(args ShardArgs, result *ShardResult) error {
+	if !w.mu.TryLock() {
+		return errors.New("worker is already running a shard")
+	}
+	defer w.mu.Unlock()
+
+	cfg := args.Config
+	log.Printf("Received shard %d/%d for %s, starting at %s", cfg.Shard+1, cfg.Shards, cfg.Target, args.StartAt.Format(time.RFC3339Nano))
+	time.Sleep(time.Until(args.StartAt))
+
+	report, err := runLoadTest(&cfg)
+	if err != nil {
+		return err
+	}
+	for _, phase := range report.raw {
+		snapshot := PhaseSnapshot{Name: phase.name, Finished: phase.finished, Metrics: make(map[string]MetricsSnapshot)}
+		for op, m := range phase.metrics {
+			snapshot.Metrics[op] = m.snapshot()
+		}
+		result.Phases = append(result.Phases, snapshot)
+	}
+	log.Printf("Shard %d/%d done", cfg.Shard+1, cfg.Shards)
+	return nil
+}
+
+// runWorker implements "loadtest worker -listen addr".
+func runWorker(args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	listen := fs.String("listen", ":7070", "address to accept coordinator connections on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	server := rpc.NewServer()
+	if err := server.Register(&Worker{}); err != nil {
+		log.Printf("Failed to register worker: %v", err)
+		return 1
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Printf("Failed to listen on %s: %v", *listen, err)
+		return 1
+	}
+	log.Printf("Worker listening on %s", ln.Addr())
+	server.Accept(ln)
+	return 0
+}
+
+// runCoordinator shards cfg across cfg.Workers, waits for every shard and
+// merges the results into one report.
+func runCoordinator(cfg *Config) (*Report, error) {
+	clients := make([]*rpc.Client, len(cfg.Workers))
+	for i, addr := range cfg.Workers {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to worker %s: %v", addr, err)
+		}
+		defer client.Close()
+		clients[i] = client
+	}
+
+	startAt := time.Now().Add(startDelay)
+	fmt.Printf("Coordinating %d workers, starting at %s\n", len(clients), startAt.Format(time.RFC3339Nano))
+	report := NewReport(cfg)
+	report.StartedAt = startAt
+
+	calls := make([]*rpc.Call, len(clients))
+	results := make([]ShardResult, len(clients))
+	for i, client := range clients {
+		args := ShardArgs{Config: shardConfig(cfg, i, len(clients)), StartAt: startAt}
+		calls[i] = client.Go("Worker.Run", args, &results[i], nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil {
+			return nil, fmt.Errorf("worker %s failed: %v", cfg.Workers[i], call.Error)
+		}
+	}
+
+	mergeShards(report, results)
+	for _, phase := range report.raw {
+		fmt.Printf("Merged %s results from %d workers.\n", phase.name, len(clients))
+		for _, op := range []string{opSet, opGet} {
+			if m, ok := phase.metrics[op]; ok {
+				m.PrintSummary(op)
+			}
+		}
+	}
+	return report, nil
+}
+
+// shardConfig returns the configuration for worker i of n: rates and
+// concurrency are split between the workers, key selection gets its own seed
+// (and its own start for the sequential distribution), and the SET phase is
+// partitioned by key.
+func shardConfig(cfg *Config, i, n int) Config {
+	shard := *cfg
+	shard.Workers = nil
+	shard.Shard, shard.Shards = i, n
+	shard.Seed = cfg.Seed + int64(i)
+	shard.Rate = splitEvenly(cfg.Rate, i, n)
+	shard.Concurrency = splitEvenly(cfg.Concurrency, i, n)
+	shard.ReportJSON, shard.ReportCSV, shard.MetricsAddr, shard.MetricsLinger = "", "", "", 0
+	if cfg.Profile != nil {
+		profile := Profile{Stages: make([]Stage, len(cfg.Profile.Stages))}
+		for j, stage := range cfg.Profile.Stages {
+			stage.Rate = splitEvenly(stage.Rate, i, n)
+			if stage.FromRate > 0 {
+				stage.FromRate = splitEvenly(stage.FromRate, i, n)
+			}
+			if stage.Concurrency > 0 {
+				stage.Concurrency = splitEvenly(stage.Concurrency, i, n)
+			}
+			profile.Stages[j] = stage
+		}
+		shard.Profile = &profile
+	}
+	return shard
+}
+
+// splitEvenly returns worker i's share of total, never less than 1.
+func splitEvenly(total, i, n int) int {
+	share := total / n
+	if i < total%n {
+		share++
+	}
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// mergeShards adds one phase to report per phase name, in the order the
+// first worker ran them, with the recorders of all workers merged.
+func mergeShards(report *Report, results []ShardResult) {
+	var order []string
+	merged := make(map[string]map[string]*Metrics)
+	finished := make(map[string]time.Time)
+	for _, result := range results {
+		for _, phase := range result.Phases {
+			if _, ok := merged[phase.Name]; !ok {
+				order = append(order, phase.Name)
+				merged[phase.Name] = make(map[string]*Metrics)
+			}
+			if phase.Finished.After(finished[phase.Name]) {
+				finished[phase.Name] = phase.Finished
+			}
+			for op, snapshot := range phase.Metrics {
+				m, ok := merged[phase.Name][op]
+				if !ok {
+					m = NewMetrics()
+					m.started = snapshot.Started
+					merged[phase.Name][op] = m
+				}
+				m.merge(snapshot)
+			}
+		}
+	}
+	for _, name := range order {
+		report.AddPhaseAt(name, merged[name], finished[name])
+	}
+}
+
+func (m *Metrics) snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MetricsSnapshot{
+		TotalRequests: m.TotalRequests,
+		Successful:    m.Successful,
+		Failed:        m.Failed,
+		Lookups:       m.Lookups,
+		Misses:        m.Misses,
+		ConnsNew:      m.ConnsNew,
+		ConnsReused:   m.ConnsReused,
+		ValueBytes:    m.ValueBytes,
+		StatusCodes:   m.StatusCodes,
+		Errors:        m.Errors,
+		Latency:       m.latency,
+		ServiceTime:   m.service,
+		Started:       m.started,
+	}
+	for _, interval := range m.series {
+		s.Series = append(s.Series, IntervalSnapshot{interval.requests, interval.failed, interval.latency})
+	}
+	return s
+}
+
+// merge adds a worker's snapshot to m. Time series are aligned by second
+// since each worker's own phase start, which the common start time keeps
+// within a few milliseconds of each other.
+func (m *Metrics) merge(s MetricsSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TotalRequests += s.TotalRequests
+	m.Successful += s.Successful
+	m.Failed += s.Failed
+	m.Lookups += s.Lookups
+	m.Misses += s.Misses
+	m.ConnsNew += s.ConnsNew
+	m.ConnsReused += s.ConnsReused
+	m.ValueBytes += s.ValueBytes
+	for code, n := range s.StatusCodes {
+		m.StatusCodes[code] += n
+	}
+	for class, n := range s.Errors {
+		m.Errors[class] += n
+	}
+	if s.Latency != nil {
+		m.latency.Merge(s.Latency)
+	}
+	if s.ServiceTime != nil {
+		m.service.Merge(s.ServiceTime)
+	}
+	if s.Started.Before(m.started) {
+		m.started = s.Started
+	}
+	for i, interval := range s.Series {
+		for len(m.series) <= i {
+			m.series = append(m.series, &intervalStats{latency: NewHistogram()})
+		}
+		m.series[i].requests += interval.Requests
+		m.series[i].failed += interval.Failed
+		if interval.Latency != nil {
+			m.series[i].latency.Merge(interval.Latency)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+const (
+	distSequential = "sequential"
+	distUniform    = "uniform"
+	distZipfian    = "zipfian"
+	distHotspot    = "hotspot"
+)
+
+var distributions = []string{distSequential, distUniform, distZipfian, distHotspot}
+
+// KeyChooser picks the index of the next key to access. Implementations are
+// not safe for concurrent use; the runners call Next from a single goroutine
+// or under a lock.
+type KeyChooser interface {
+	Next() int
+}
+
+func newKeyChooser(cfg *Config, rng *rand.Rand) (KeyChooser, error) {
+	switch cfg.Distribution {
+	case distSequential:
+		c := &sequentialChooser{n: cfg.Keys}
+		if cfg.Shards > 1 {
+			// Workers start evenly spread over the key space instead of
+			// all reading the same key at the same time.
+			c.next = cfg.Shard * cfg.Keys / cfg.Shards
+		}
+		return c, nil
+	case distUniform:
+		return &uniformChooser{n: cfg.Keys, rng: rng}, nil
+	case distZipfian:
+		return newZipfianChooser(cfg.Keys, cfg.ZipfSkew, rng)
+	case distHotspot:
+		return newHotspotChooser(cfg.Keys, cfg.HotKeys, cfg.HotOps, rng)
+	default:
+		return nil, fmt.Errorf("unknown distribution %q", cfg.Distribution)
+	}
+}
+
+// sequentialChooser scans the key space in order and wraps around.
+type sequentialChooser struct {
+	n    int
+	next int
+}
+
+func (c *sequentialChooser) Next() int {
+	i := c.next
+	c.next = (c.next + 1) % c.n
+	return i
+}
+
+// uniformChooser gives every key the same probability.
+type uniformChooser struct {
+	n   int
+	rng *rand.Rand
+}
+
+func (c *uniformChooser) Next() int {
+	return c.rng.Intn(c.n)
+}
+
+// zipfianChooser draws key ranks from a Zipf distribution where the key of
+// rank i is accessed with probability proportional to 1/i^skew. Key 0 is the
+// most popular.
+//
+// For skew < 1 (YCSB's default is 0.99) it uses the rejection-free method from
+// Gray et al., "Quickly Generating Billion-Record Synthetic Databases", as
+// YCSB does. math/rand.Zipf only supports skew > 1, so it is used for that range.
+type zipfianChooser struct {
+	n     int
+	rng   *rand.Rand
+	zipf  *rand.Zipf
+	theta float64
+	alpha float64
+	zetan float64
+	eta   float64
+}
+
+func newZipfianChooser(n int, skew float64, rng *rand.Rand) (*zipfianChooser, error) {
+	if skew <= 0 || skew == 1 {
+		return nil, fmt.Errorf("zipf skew must be positive and not equal to 1, got %v", skew)
+	}
+	c := &zipfianChooser{n: n, rng: rng, theta: skew}
+	if skew > 1 {
+		c.zipf = rand.NewZipf(rng, skew, 1, uint64(n-1))
+		return c, nil
+	}
+	c.zetan = zeta(n, skew)
+	c.alpha = 1 / (1 - skew)
+	c.eta = (1 - math.Pow(2/float64(n), 1-skew)) / (1 - zeta(2, skew)/c.zetan)
+	return c, nil
+}
+
+func zeta(n int, theta float64) float64 {
+	sum := 0.0
+	for i := 1; i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+func (c *zipfianChooser) Next() int {
+	if c.zipf != nil {
+		return int(c.zipf.Uint64())
+	}
+	u := c.rng.Float64()
+	uz := u * c.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+math.Pow(0.5, c.theta) {
+		return 1
+	}
+	i := int(float64(c.n) * math.Pow(c.eta*u-c.eta+1, c.alpha))
+	if i >= c.n {
+		i = c.n - 1
+	}
+	return i
+}
+
+// hotspotChooser sends hotOps of the accesses to the first hotKeys fraction
+// of the key space and spreads the rest uniformly over the cold keys.
+type hotspotChooser struct {
+	n      int
+	hot    int
+	hotOps float64
+	rng    *rand.Rand
+}
+
+func newHotspotChooser(n int, hotKeys, hotOps float64, rng *rand.Rand) (*hotspotChooser, error) {
+	if hotKeys <= 0 || hotKeys >= 1 {
+		return nil, fmt.Errorf("hot-keys must be between 0 and 1, got %v", hotKeys)
+	}
+	if hotOps < 0 || hotOps > 1 {
+		return nil, fmt.Errorf("hot-ops must be between 0 and 1, got %v", hotOps)
+	}
+	hot := int(float64(n) * hotKeys)
+	if hot < 1 {
+		hot = 1
+	}
+	return &hotspotChooser{n: n, hot: hot, hotOps: hotOps, rng: rng}, nil
+}
+
+func (c *hotspotChooser) Next() int {
+	if c.hot == c.n || c.rng.Float64() < c.hotOps {
+		return c.rng.Intn(c.hot)
+	}
+	return c.hot + c.rng.Intn(c.n-c.hot)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Driver sends operations to the system under test. The HTTP driver goes
+// through the /set and /get handlers of the cache servers, the RESP driver
+// talks to Redis or Dragonfly directly, so the HTTP layer and the cache
+// engine can be benchmarked with the same workload and report format.
+//
+// Do must be safe for concurrent use.
+type Driver interface {
+	Do(op operation, metrics *Metrics) outcome
+	Close() error
+}
+
+// outcome is the result of one operation as seen by a driver.
+type outcome struct {
+	status int // HTTP status code, 0 for protocols without one
+	misses int // keys the server reported as not found
+	err    error
+	class  string // error class, set when err is not nil
+}
+
+func failed(class string, status int, err error) outcome {
+	return outcome{status: status, err: err, class: class}
+}
+
+// newDriver picks the driver from the target URL scheme: http:// and
+// https:// use the HTTP API selected with -api, redis:// speaks RESP to the
+// cache itself.
+func newDriver(cfg *Config) (Driver, error) {
+	u, err := url.Parse(cfg.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %v", cfg.Target, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return newHTTPDriver(cfg), nil
+	case "redis":
+		return newRESPDriver(u.Host, cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported target scheme %q, expected http, https or redis", u.Scheme)
+	}
+}
+
+// execute sends op and records its latency measured from start. In open-loop
+// mode start is the intended send time rather than the moment the request
+// actually left, so queueing delay is charged to the request; the service
+// time from the actual send is recorded next to it.
+func execute(driver Driver, op operation, start time.Time, metrics map[string]*Metrics) {
+	m := metrics[op.name]
+	exporter.start(op.name)
+	sent := time.Now()
+	out := driver.Do(op, m)
+	latency, service := time.Since(start), time.Since(sent)
+	if out.err != nil {
+		if out.class != errHTTPStatus {
+			fmt.Printf("Failed to %s key %s: %v\n", strings.ToLower(op.name), op.key, out.err)
+		}
+		exporter.finish(op.name, out.class, latency, 0)
+		m.RecordFailure(out.class, out.status)
+		return
+	}
+	exporter.finish(op.name, "success", latency, out.misses)
+	lookups := 0
+	if op.name == opGet {
+		lookups = len(op.keys())
+	}
+	m.RecordSuccess(out.status, latency, service, lookups, out.misses, len(op.value))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// The histogram uses HdrHistogram-style log-linear buckets: every power of two
+// is split into histSubBuckets linear sub-buckets, so any recorded value is
+// reported with a relative error below 1/histSubBuckets (~0.8%).
+const (
+	histSubBucketBits = 7
+	histSubBuckets    = 1 << histSubBucketBits
+)
+
+// Quantiles reported in the latency summary and distribution table.
+var reportedQuantiles = []float64{0.50, 0.75, 0.90, 0.95, 0.99, 0.999, 0.9999, 1.0}
+
+// Histogram records latencies in nanoseconds. It is not safe for concurrent
+// use; Metrics guards it with its own mutex.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func bucketIndex(v int64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBucketBits - 1
+	mantissa := v >> uint(shift)
+	return shift*histSubBuckets + int(mantissa)
+}
+
+// bucketValue returns the highest value that maps to bucket i, so quantiles
+// never under-report latency.
+func bucketValue(i int) int64 {
+	if i < histSubBuckets {
+		return int64(i)
+	}
+	shift := i/histSubBuckets - 1
+	mantissa := int64(i - shift*histSubBuckets)
+	return (mantissa+1)<<uint(shift) - 1
+}
+
+func (h *Histogram) Record(latency time.Duration) {
+	v := int64(latency)
+	if v < 0 {
+		v = 0
+	}
+	idx := bucketIndex(v)
+	if idx >= len(h.counts) {
+		grown := make([]uint64, idx+histSubBuckets)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[idx]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += v
+}
+
+// Merge adds the values recorded in other to h. Because both histograms use
+// the same bucket layout the merge is exact, which lets a coordinator combine
+// worker results without losing percentile accuracy.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		grown := make([]uint64, len(other.counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.total += other.total
+	h.sum += other.sum
+}
+
+// histogramState is the wire form of a Histogram.
+type histogramState struct {
+	Counts []uint64
+	Total  uint64
+	Sum    int64
+	Min    int64
+	Max    int64
+}
+
+func (h *Histogram) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(histogramState{h.counts, h.total, h.sum, h.min, h.max})
+	return buf.Bytes(), err
+}
+
+func (h *Histogram) GobDecode(data []byte) error {
+	var state histogramState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	h.counts, h.total, h.sum, h.min, h.max = state.Counts, state.Total, state.Sum, state.Min, state.Max
+	return nil
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / int64(h.total))
+}
+
+// ValueAtQuantile returns the latency at quantile q (0 < q <= 1).
+func (h *Histogram) ValueAtQuantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return time.Duration(h.max)
+	}
+	target := uint64(math.Ceil(q * float64(h.total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := bucketValue(i)
+			// Clamp to the observed range so p0/p100 match min/max exactly.
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// PrintDistribution writes a percentile table in the spirit of wrk2 and
+// HdrHistogram's percentile output: latency, quantile and cumulative count.
+func (h *Histogram) PrintDistribution(w io.Writer) {
+	fmt.Fprintf(w, "  %10s  %12s  %10s\n", "Percentile", "Latency", "Count")
+	for _, q := range reportedQuantiles {
+		fmt.Fprintf(w, "  %9.3f%%  %12v  %10d\n", q*100, h.ValueAtQuantile(q), uint64(math.Ceil(q*float64(h.total))))
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	rampNone   = ""
+	rampLinear = "linear"
+	rampStep   = "step"
+)
+
+// Stage is one segment of a load profile. Every phase of a run (set, get or
+// mixed) goes through the stages of the profile in order. Without -profile a
+// phase is a single unnamed stage built from -duration, -rate and -concurrency.
+type Stage struct {
+	Name     string   `json:"name"`
+	Duration Duration `json:"duration"`
+	// Rate is the open-loop request rate at the end of the stage. With a ramp
+	// the rate moves from FromRate to Rate over the stage, either linearly or
+	// in Steps equal steps.
+	Rate     int    `json:"rate"`
+	FromRate int    `json:"from_rate,omitempty"`
+	Ramp     string `json:"ramp,omitempty"`
+	Steps    int    `json:"steps,omitempty"`
+	// Concurrency overrides -concurrency for this stage: the in-flight limit
+	// in open-loop mode and the number of workers in closed-loop mode.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Warmup stages are run but excluded from the summary and the report.
+	// With SteadyWindow set, a warmup stage ends early once the last
+	// SteadyWindow one-second samples agree within SteadyTolerance.
+	Warmup          bool    `json:"warmup,omitempty"`
+	SteadyWindow    int     `json:"steady_window,omitempty"`
+	SteadyTolerance float64 `json:"steady_tolerance,omitempty"`
+}
+
+// Profile is the content of the file passed with -profile.
+type Profile struct {
+	Stages []Stage `json:"stages"`
+}
+
+// Duration is a time.Duration that reads and writes as a string such as "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func loadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(p.Stages) == 0 {
+		return nil, fmt.Errorf("%s: profile has no stages", path)
+	}
+	for i := range p.Stages {
+		if err := p.Stages[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: stage %d: %v", path, i+1, err)
+		}
+	}
+	return &p, nil
+}
+
+// stagesFor returns the profile stages, or a single stage built from the
+// command-line flags when no profile is configured.
+func stagesFor(cfg *Config) []Stage {
+	if cfg.Profile != nil {
+		return cfg.Profile.Stages
+	}
+	return []Stage{{Duration: Duration(cfg.Duration), Rate: cfg.Rate, Concurrency: cfg.Concurrency}}
+}
+
+func (s *Stage) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if s.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	switch s.Ramp {
+	case rampNone:
+	case rampLinear, rampStep:
+		if s.FromRate <= 0 {
+			return fmt.Errorf("%s ramp needs a positive from_rate", s.Ramp)
+		}
+		if s.Ramp == rampStep && s.Steps < 1 {
+			return fmt.Errorf("step ramp needs steps >= 1")
+		}
+	default:
+		return fmt.Errorf("unknown ramp %q, expected %s or %s", s.Ramp, rampLinear, rampStep)
+	}
+	if s.SteadyWindow > 0 && !s.Warmup {
+		return fmt.Errorf("steady_window only applies to warmup stages")
+	}
+	if s.SteadyWindow > 0 && s.SteadyTolerance <= 0 {
+		s.SteadyTolerance = 0.1
+	}
+	return nil
+}
+
+// concurrency returns the stage's in-flight limit or worker count.
+func (s *Stage) concurrency(cfg *Config) int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return cfg.Concurrency
+}
+
+// rateAt returns the open-loop request rate elapsed into the stage.
+func (s *Stage) rateAt(elapsed time.Duration) float64 {
+	progress := float64(elapsed) / float64(s.Duration)
+	if progress > 1 {
+		progress = 1
+	}
+	from, to := float64(s.FromRate), float64(s.Rate)
+	switch s.Ramp {
+	case rampLinear:
+		return from + (to-from)*progress
+	case rampStep:
+		// Steps+1 plateaus: from_rate, then Steps equal increments up to rate.
+		step := math.Min(math.Floor(progress*float64(s.Steps+1)), float64(s.Steps))
+		return from + (to-from)*step/float64(s.Steps)
+	default:
+		return to
+	}
+}
+
+func (s *Stage) describe(cfg *Config) string {
+	if cfg.Mode == modeClosed {
+		return fmt.Sprintf("%v with %d workers", time.Duration(s.Duration), s.concurrency(cfg))
+	}
+	switch s.Ramp {
+	case rampLinear:
+		return fmt.Sprintf("%v ramping linearly from %d to %d RPS", time.Duration(s.Duration), s.FromRate, s.Rate)
+	case rampStep:
+		return fmt.Sprintf("%v ramping from %d to %d RPS in %d steps", time.Duration(s.Duration), s.FromRate, s.Rate, s.Steps)
+	default:
+		return fmt.Sprintf("%v at %d RPS", time.Duration(s.Duration), s.Rate)
+	}
+}
+
+// isSteady reports whether the last window complete one-second samples of m
+// have throughput and median latency within tolerance of their mean.
+func (m *Metrics) isSteady(window int, tolerance float64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The current second is still filling up, so only look at complete ones.
+	complete := int(time.Since(m.started) / time.Second)
+	if complete > len(m.series) {
+		complete = len(m.series)
+	}
+	if complete < window {
+		return false
+	}
+	samples := m.series[complete-window : complete]
+	requests := make([]float64, window)
+	medians := make([]float64, window)
+	for i, interval := range samples {
+		requests[i] = float64(interval.requests)
+		medians[i] = float64(interval.latency.ValueAtQuantile(0.5))
+	}
+	return withinTolerance(requests, tolerance) && withinTolerance(medians, tolerance)
+}
+
+func withinTolerance(values []float64, tolerance float64) bool {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return false
+	}
+	for _, v := range values {
+		if math.Abs(v-mean)/mean > tolerance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promBuckets are the upper bounds, in seconds, of the exported latency
+// histogram. They cover sub-millisecond cache hits up to requests that hit
+// the default 10s timeout.
+var promBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// exporter serves live metrics for the run when -metrics-addr is set. It is
+// nil otherwise, and all of its methods are no-ops on a nil receiver.
+var exporter *promExporter
+
+// promExporter keeps Prometheus-style counters, gauges and histograms for the
+// requests sent during the run and writes them in the text exposition format.
+// Unlike Metrics it is never reset between phases, so a run can be graphed
+// end to end next to the server-side metrics.
+type promExporter struct {
+	target   string
+	mu       sync.Mutex
+	requests map[[2]string]uint64 // [operation, outcome]
+	misses   map[string]uint64
+	inFlight map[string]int64
+	latency  map[string]*promHistogram
+}
+
+type promHistogram struct {
+	counts []uint64 // per bucket, not cumulative; the last slot is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newPromExporter(target string) *promExporter {
+	return &promExporter{
+		target:   target,
+		requests: make(map[[2]string]uint64),
+		misses:   make(map[string]uint64),
+		inFlight: make(map[string]int64),
+		latency:  make(map[string]*promHistogram),
+	}
+}
+
+// serve exposes /metrics on addr in the background.
+func (e *promExporter) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		e.write(w)
+	})
+	go func() {
+		log.Printf("Serving load test metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+func (e *promExporter) start(op string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight[strings.ToLower(op)]++
+}
+
+// finish records the end of a request started with start. outcome is
+// "success" or one of the error classes.
+func (e *promExporter) finish(op, outcome string, latency time.Duration, misses int) {
+	if e == nil {
+		return
+	}
+	op = strings.ToLower(op)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inFlight[op]--
+	e.requests[[2]string{op, outcome}]++
+	if op == "get" {
+		e.misses[op] += uint64(misses)
+	}
+	if outcome != "success" {
+		return
+	}
+	h := e.latency[op]
+	if h == nil {
+		h = &promHistogram{counts: make([]uint64, len(promBuckets)+1)}
+		e.latency[op] = h
+	}
+	seconds := latency.Seconds()
+	i := sort.SearchFloat64s(promBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+func (e *promExporter) write(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP loadtest_requests_total Requests completed by the load generator, by outcome.")
+	fmt.Fprintln(w, "# TYPE loadtest_requests_total counter")
+	keys := make([][2]string, 0, len(e.requests))
+	for k := range e.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "loadtest_requests_total{operation=\"%s\",target=\"%s\",outcome=\"%s\"} %d\n", escapeLabel(k[0]), escapeLabel(e.target), escapeLabel(k[1]), e.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP loadtest_key_misses_total Keys the server reported as not found.")
+	fmt.Fprintln(w, "# TYPE loadtest_key_misses_total counter")
+	for _, op := range sortedKeys(e.misses) {
+		fmt.Fprintf(w, "loadtest_key_misses_total{operation=\"%s\",target=\"%s\"} %d\n", escapeLabel(op), escapeLabel(e.target), e.misses[op])
+	}
+
+	fmt.Fprintln(w, "# HELP loadtest_requests_in_flight Requests sent and not yet completed.")
+	fmt.Fprintln(w, "# TYPE loadtest_requests_in_flight gauge")
+	for _, op := range sortedKeys(e.inFlight) {
+		fmt.Fprintf(w, "loadtest_requests_in_flight{operation=\"%s\",target=\"%s\"} %d\n", escapeLabel(op), escapeLabel(e.target), e.inFlight[op])
+	}
+
+	fmt.Fprintln(w, "# HELP loadtest_request_duration_seconds Latency of successful requests.")
+	fmt.Fprintln(w, "# TYPE loadtest_request_duration_seconds histogram")
+	for _, op := range sortedKeys(e.latency) {
+		h := e.latency[op]
+		labels := fmt.Sprintf("operation=\"%s\",target=\"%s\"", escapeLabel(op), escapeLabel(e.target))
+		var cumulative uint64
+		for i, bound := range promBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "loadtest_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "loadtest_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "loadtest_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "loadtest_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the text exposition format, which
+// only knows \\, \" and \n. %q would also escape other characters the Go
+// way, e.g. non-printable runes as \u escapes, which Prometheus reads literally.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
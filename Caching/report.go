@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report is the machine-readable result of one load test run. It is written
+// as JSON with -report-json and as CSV with -report-csv, and two JSON reports
+// can be diffed with the compare subcommand.
+type Report struct {
+	Config     *Config       `json:"config"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Phases     []PhaseReport `json:"phases"`
+
+	// raw keeps the recorders behind each phase so a worker can ship them
+	// to the coordinator for merging.
+	raw []rawPhase
+}
+
+type rawPhase struct {
+	name     string
+	finished time.Time
+	metrics  map[string]*Metrics
+}
+
+// PhaseReport covers one phase of the run (set, get or mixed). Operations and
+// TimeSeries are keyed by operation name (SET, GET).
+type PhaseReport struct {
+	Name       string                       `json:"name"`
+	StartedAt  time.Time                    `json:"started_at"`
+	FinishedAt time.Time                    `json:"finished_at"`
+	Operations map[string]*OperationSummary `json:"operations"`
+	TimeSeries map[string][]Sample          `json:"time_series"`
+}
+
+// OperationSummary is the final summary for one operation in a phase.
+// Latencies are in microseconds. Latency is measured from each request's
+// intended send time and ServiceTime from its actual one.
+type OperationSummary struct {
+	TotalRequests int            `json:"total_requests"`
+	Successful    int            `json:"successful"`
+	Failed        int            `json:"failed"`
+	Lookups       int            `json:"lookups"`
+	Misses        int            `json:"misses"`
+	ConnsNew      int            `json:"conns_new"`
+	ConnsReused   int            `json:"conns_reused"`
+	ValueBytes    int64          `json:"value_bytes"`
+	Throughput    float64        `json:"throughput_rps"`
+	Bandwidth     float64        `json:"value_mb_per_sec"`
+	HitRate       float64        `json:"hit_rate"`
+	ErrorRate     float64        `json:"error_rate"`
+	StatusCodes   map[int]int    `json:"status_codes"`
+	Errors        map[string]int `json:"errors"`
+	Latency       LatencySummary `json:"latency_us"`
+	ServiceTime   LatencySummary `json:"service_time_us"`
+}
+
+type LatencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p99_9"`
+	Max  float64 `json:"max"`
+}
+
+// Sample is one second of a phase's time series.
+type Sample struct {
+	Second     int            `json:"second"`
+	Requests   int            `json:"requests"`
+	Failed     int            `json:"failed"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    LatencySummary `json:"latency_us"`
+}
+
+func NewReport(cfg *Config) *Report {
+	return &Report{Config: cfg, StartedAt: time.Now()}
+}
+
+// AddPhase summarizes the metrics of a phase that just finished. The phase is
+// assumed to have started when its Metrics were created.
+func (r *Report) AddPhase(name string, metrics map[string]*Metrics) {
+	r.AddPhaseAt(name, metrics, time.Now())
+}
+
+// AddPhaseAt summarizes the metrics of a phase that finished at finished.
+func (r *Report) AddPhaseAt(name string, metrics map[string]*Metrics, finished time.Time) {
+	r.raw = append(r.raw, rawPhase{name: name, finished: finished, metrics: metrics})
+	phase := PhaseReport{
+		Name:       name,
+		FinishedAt: finished,
+		Operations: make(map[string]*OperationSummary),
+		TimeSeries: make(map[string][]Sample),
+	}
+	for op, m := range metrics {
+		if phase.StartedAt.IsZero() || m.started.Before(phase.StartedAt) {
+			phase.StartedAt = m.started
+		}
+		phase.Operations[op] = m.Summary(phase.FinishedAt)
+		phase.TimeSeries[op] = m.TimeSeries()
+	}
+	r.Phases = append(r.Phases, phase)
+	r.FinishedAt = phase.FinishedAt
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func summarizeLatency(h *Histogram) LatencySummary {
+	return LatencySummary{
+		Min:  micros(h.Min()),
+		Mean: micros(h.Mean()),
+		P50:  micros(h.ValueAtQuantile(0.50)),
+		P90:  micros(h.ValueAtQuantile(0.90)),
+		P99:  micros(h.ValueAtQuantile(0.99)),
+		P999: micros(h.ValueAtQuantile(0.999)),
+		Max:  micros(h.Max()),
+	}
+}
+
+// Summary returns the final numbers for the phase that ended at finished.
+func (m *Metrics) Summary(finished time.Time) *OperationSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := &OperationSummary{
+		TotalRequests: m.TotalRequests,
+		Successful:    m.Successful,
+		Failed:        m.Failed,
+		Lookups:       m.Lookups,
+		Misses:        m.Misses,
+		ConnsNew:      m.ConnsNew,
+		ConnsReused:   m.ConnsReused,
+		ValueBytes:    m.ValueBytes,
+		StatusCodes:   make(map[int]int, len(m.StatusCodes)),
+		Errors:        make(map[string]int, len(m.Errors)),
+		Latency:       summarizeLatency(m.latency),
+		ServiceTime:   summarizeLatency(m.service),
+	}
+	if elapsed := finished.Sub(m.started).Seconds(); elapsed > 0 {
+		s.Throughput = float64(m.Successful) / elapsed
+		s.Bandwidth = float64(m.ValueBytes) / elapsed / 1e6
+	}
+	if m.Lookups > 0 {
+		s.HitRate = float64(m.Lookups-m.Misses) / float64(m.Lookups)
+	}
+	if m.TotalRequests > 0 {
+		s.ErrorRate = float64(m.Failed) / float64(m.TotalRequests)
+	}
+	for code, n := range m.StatusCodes {
+		s.StatusCodes[code] = n
+	}
+	for class, n := range m.Errors {
+		s.Errors[class] = n
+	}
+	return s
+}
+
+// TimeSeries returns one sample per second since the Metrics were created.
+func (m *Metrics) TimeSeries() []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	samples := make([]Sample, len(m.series))
+	for i, interval := range m.series {
+		samples[i] = Sample{
+			Second:     i,
+			Requests:   interval.requests,
+			Failed:     interval.failed,
+			Throughput: float64(interval.requests - interval.failed),
+			Latency:    summarizeLatency(interval.latency),
+		}
+	}
+	return samples
+}
+
+// Save writes the report to the given paths; empty paths are skipped.
+func (r *Report) Save(jsonPath, csvPath string) error {
+	if jsonPath != "" {
+		if err := r.writeJSON(jsonPath); err != nil {
+			return err
+		}
+		fmt.Println("JSON report written to", jsonPath)
+	}
+	if csvPath != "" {
+		if err := r.writeCSV(csvPath); err != nil {
+			return err
+		}
+		fmt.Println("CSV report written to", csvPath)
+	}
+	return nil
+}
+
+func (r *Report) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// writeCSV writes one row per phase, operation and second, followed by a
+// summary row per phase and operation with "total" in the second column.
+func (r *Report) writeCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"phase", "operation", "second", "requests", "failed", "throughput_rps",
+		"min_us", "mean_us", "p50_us", "p90_us", "p99_us", "p99_9_us", "max_us"})
+	for _, phase := range r.Phases {
+		for _, op := range sortedOperations(phase.Operations) {
+			for _, sample := range phase.TimeSeries[op] {
+				w.Write(csvRow(phase.Name, op, strconv.Itoa(sample.Second), sample.Requests, sample.Failed, sample.Throughput, sample.Latency))
+			}
+			sum := phase.Operations[op]
+			w.Write(csvRow(phase.Name, op, "total", sum.TotalRequests, sum.Failed, sum.Throughput, sum.Latency))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func csvRow(phase, op, second string, requests, failed int, throughput float64, l LatencySummary) []string {
+	row := []string{phase, op, second, strconv.Itoa(requests), strconv.Itoa(failed), formatFloat(throughput)}
+	for _, v := range []float64{l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max} {
+		row = append(row, formatFloat(v))
+	}
+	return row
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func sortedOperations(ops map[string]*OperationSummary) []string {
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &r, nil
+}
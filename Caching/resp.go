@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respDriver sends SET, GET and MGET straight to Redis or Dragonfly using the
+// RESP wire protocol, bypassing the HTTP servers. Connections are kept in a
+// pool sized to the configured concurrency.
+type respDriver struct {
+	addr    string
+	timeout time.Duration
+	pool    chan *respConn
+}
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// respError is an error reply (-ERR ...) sent by the server.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+func newRESPDriver(addr string, cfg *Config) *respDriver {
+	return &respDriver{
+		addr:    addr,
+		timeout: cfg.RequestTimeout,
+		pool:    make(chan *respConn, cfg.Concurrency),
+	}
+}
+
+// get returns a pooled connection or dials a new one, recording which of the
+// two happened.
+func (d *respDriver) get(metrics *Metrics) (*respConn, error) {
+	select {
+	case c := <-d.pool:
+		metrics.RecordConn(true)
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", d.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	metrics.RecordConn(false)
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// put returns c to the pool, closing it if the pool is full.
+func (d *respDriver) put(c *respConn) {
+	select {
+	case d.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (d *respDriver) Do(op operation, metrics *Metrics) outcome {
+	c, err := d.get(metrics)
+	if err != nil {
+		return failed(classifyError(err), 0, err)
+	}
+	if d.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+
+	var args []string
+	switch {
+	case op.name == opSet:
+		args = []string{"SET", op.key, op.value}
+	case len(op.batch) > 0:
+		args = append([]string{"MGET"}, op.keys()...)
+	default:
+		args = []string{"GET", op.key}
+	}
+
+	reply, err := c.roundTrip(args...)
+	if err != nil {
+		var replyErr respError
+		if errors.As(err, &replyErr) {
+			// The connection is still in sync after an error reply.
+			d.put(c)
+			return failed(errServer, 0, err)
+		}
+		c.conn.Close()
+		return failed(classifyError(err), 0, err)
+	}
+	d.put(c)
+
+	out := outcome{}
+	switch v := reply.(type) {
+	case nil:
+		out.misses = 1
+	case []interface{}:
+		for _, item := range v {
+			if item == nil {
+				out.misses++
+			}
+		}
+	}
+	return out
+}
+
+func (d *respDriver) Close() error {
+	for {
+		select {
+		case c := <-d.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *respConn) roundTrip(args ...string) (interface{}, error) {
+	if err := writeCommand(c.w, args...); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// readReply decodes one RESP2 reply. Simple strings are returned as string,
+// integers as int64, bulk strings as []byte, arrays as []interface{} and nil
+// bulk strings or arrays as nil. Error replies are returned as respError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed RESP line %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, respError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// An error element is returned only after the rest of the array
+		// is read, so the connection stays in sync for the next command.
+		items := make([]interface{}, n)
+		var replyErr error
+		for i := range items {
+			items[i], err = readReply(r)
+			var elemErr respError
+			switch {
+			case errors.As(err, &elemErr):
+				if replyErr == nil {
+					replyErr = err
+				}
+			case err != nil:
+				return nil, err
+			}
+		}
+		if replyErr != nil {
+			return nil, replyErr
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown RESP type %q", kind)
+	}
+}
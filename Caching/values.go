@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+const (
+	sizeFixed     = "fixed"
+	sizeUniform   = "uniform"
+	sizeLogNormal = "lognormal"
+
+	contentText         = "text"
+	contentRandom       = "random"
+	contentCompressible = "compressible"
+
+	payloadRaw  = "raw"
+	payloadJSON = "json"
+)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// compressiblePattern repeats, so compressible values shrink to a fraction of
+// their size under any compressor, unlike random content.
+const compressiblePattern = "The quick brown fox jumps over the lazy dog. "
+
+// Values produces the value written for each key. Sizes are drawn once per
+// key, so a key keeps its size across writes. Random and compressible content
+// is sliced out of one shared buffer, so large values cost no memory per key.
+type Values struct {
+	keys    []string
+	sizes   []int
+	content string
+	cfg     *Config
+}
+
+func newValues(cfg *Config, keys []string) (*Values, error) {
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	v := &Values{keys: keys, sizes: make([]int, len(keys)), cfg: cfg}
+
+	maxSize := 0
+	for i := range v.sizes {
+		size, err := drawSize(cfg, rng)
+		if err != nil {
+			return nil, err
+		}
+		v.sizes[i] = size
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+
+	// Leave room past the largest value so different keys start at
+	// different offsets of the buffer.
+	bufLen := maxSize + 4096
+	switch cfg.ValueContent {
+	case contentText:
+	case contentRandom:
+		buf := make([]byte, bufLen)
+		for i := range buf {
+			buf[i] = alphanumeric[rng.Intn(len(alphanumeric))]
+		}
+		v.content = string(buf)
+	case contentCompressible:
+		v.content = strings.Repeat(compressiblePattern, bufLen/len(compressiblePattern)+1)
+	default:
+		return nil, fmt.Errorf("unknown value content %q", cfg.ValueContent)
+	}
+	return v, nil
+}
+
+func drawSize(cfg *Config, rng *rand.Rand) (int, error) {
+	var size int
+	switch cfg.ValueDist {
+	case sizeFixed:
+		size = cfg.ValueSize
+	case sizeUniform:
+		size = cfg.ValueMin + rng.Intn(cfg.ValueMax-cfg.ValueMin+1)
+	case sizeLogNormal:
+		// The median of a log-normal distribution is exp(mu), so -value-size
+		// sets the typical size and -value-sigma how heavy the tail is.
+		size = int(float64(cfg.ValueSize) * math.Exp(cfg.ValueSigma*rng.NormFloat64()))
+		if size < cfg.ValueMin {
+			size = cfg.ValueMin
+		}
+		if cfg.ValueMax > 0 && size > cfg.ValueMax {
+			size = cfg.ValueMax
+		}
+	default:
+		return 0, fmt.Errorf("unknown value size distribution %q", cfg.ValueDist)
+	}
+	return size, nil
+}
+
+// For returns the value for the key at index i.
+func (v *Values) For(i int) string {
+	size := v.sizes[i]
+	if v.cfg.Payload != payloadJSON {
+		return v.body(i, size)
+	}
+	// Size the occupation field so the whole document is about size bytes.
+	doc := requestData{Name: v.keys[i], Age: i % 100}
+	empty, _ := json.Marshal(doc)
+	doc.Occupation = v.body(i, size-len(empty))
+	data, _ := json.Marshal(doc)
+	return string(data)
+}
+
+func (v *Values) body(i, size int) string {
+	if size < 0 {
+		size = 0
+	}
+	if v.cfg.ValueContent == contentText {
+		value := fmt.Sprintf("value-%d", i)
+		if pad := size - len(value); pad > 0 {
+			value += strings.Repeat("x", pad)
+		}
+		return value
+	}
+	offset := (i * 7919) % (len(v.content) - size + 1)
+	return v.content[offset : offset+size]
+}
+
+// MeanSize is the average drawn value size in bytes.
+func (v *Values) MeanSize() float64 {
+	if len(v.sizes) == 0 {
+		return 0
+	}
+	total := 0
+	for _, size := range v.sizes {
+		total += size
+	}
+	return float64(total) / float64(len(v.sizes))
+}
+
+func (v *Values) describe() string {
+	switch v.cfg.ValueDist {
+	case sizeUniform:
+		return fmt.Sprintf("uniform %d-%d bytes, %s content, %s payload, mean %.0f bytes", v.cfg.ValueMin, v.cfg.ValueMax, v.cfg.ValueContent, v.cfg.Payload, v.MeanSize())
+	case sizeLogNormal:
+		return fmt.Sprintf("log-normal median %d bytes (sigma %.2f), %s content, %s payload, mean %.0f bytes", v.cfg.ValueSize, v.cfg.ValueSigma, v.cfg.ValueContent, v.cfg.Payload, v.MeanSize())
+	default:
+		return fmt.Sprintf("fixed %d bytes, %s content, %s payload", v.cfg.ValueSize, v.cfg.ValueContent, v.cfg.Payload)
+	}
+}